@@ -0,0 +1,111 @@
+package s3fileserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// cbcBufferBlocks is the number of blocks a cbc reader decrypts at once.
+const cbcBufferBlocks = 2048
+
+var errCBCPadding = errors.New("invalid cbc padding")
+
+// cbcStorageRange returns the storage byte range holding the plaintext range
+// start-end of a cbc object, which is an iv followed by the ciphertext. It
+// starts at the block before the one holding start, the iv of the first
+// block, since every cbc block is decrypted with its predecessor.
+func cbcStorageRange(start int64, end int64) (int64, int64) {
+	return start / aes.BlockSize * aes.BlockSize, end/aes.BlockSize*aes.BlockSize + 2*aes.BlockSize - 1
+}
+
+// cbcPlaintextSize returns the plaintext size of a cbc object from its size
+// and its last two blocks, the last one holding the pkcs7 padding.
+func cbcPlaintextSize(block cipher.Block, fileSize int64, tail []byte) (int64, error) {
+	if len(tail) != 2*aes.BlockSize {
+		return 0, fmt.Errorf("cbc tail is %d bytes, must be %d", len(tail), 2*aes.BlockSize)
+	}
+
+	last := make([]byte, aes.BlockSize)
+	cipher.NewCBCDecrypter(block, tail[:aes.BlockSize]).CryptBlocks(last, tail[aes.BlockSize:])
+
+	padding := int(last[aes.BlockSize-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return 0, errCBCPadding
+	}
+	for _, b := range last[aes.BlockSize-padding:] {
+		if int(b) != padding {
+			return 0, errCBCPadding
+		}
+	}
+
+	return fileSize - aes.BlockSize - int64(padding), nil
+}
+
+type cbcReader struct {
+	block  cipher.Block
+	reader io.Reader
+	mode   cipher.BlockMode
+	skip   int
+	buf    []byte
+	plain  []byte
+}
+
+// NewCBCReader decrypts reader, which must be positioned at the block before
+// the one holding offset, the iv for the first block. The padding is not
+// stripped, the caller limits the reader to the plaintext size.
+func NewCBCReader(reader io.Reader, block cipher.Block, offset int64) (*cbcReader, error) {
+	if block.BlockSize() != aes.BlockSize {
+		return nil, fmt.Errorf("invalid block size %d, must be %d", block.BlockSize(), aes.BlockSize)
+	}
+
+	return &cbcReader{
+		block:  block,
+		reader: reader,
+		skip:   int(offset % aes.BlockSize),
+		buf:    make([]byte, cbcBufferBlocks*aes.BlockSize),
+	}, nil
+}
+
+func (r *cbcReader) Read(p []byte) (int, error) {
+	// the first block read is the iv of the following ones
+	if r.mode == nil {
+		iv := make([]byte, aes.BlockSize)
+		if _, err := io.ReadFull(r.reader, iv); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		r.mode = cipher.NewCBCDecrypter(r.block, iv)
+	}
+
+	for len(r.plain) == 0 {
+		n, err := io.ReadFull(r.reader, r.buf)
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		if n%aes.BlockSize != 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		plain := r.buf[:n]
+		r.mode.CryptBlocks(plain, plain)
+
+		// drop the bytes before the requested offset in the first block
+		if r.skip > 0 {
+			plain = plain[r.skip:]
+			r.skip = 0
+		}
+		r.plain = plain
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
@@ -0,0 +1,74 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// TestCBCRoundTrip sizes plaintexts of every padding length from their last
+// two blocks and decrypts ranges in the first block, which needs the iv,
+// and around later block boundaries.
+func TestCBCRoundTrip(t *testing.T) {
+	block := testAESBlock(t)
+
+	for n := 0; n <= 3*aes.BlockSize+1; n++ {
+		plain := testPlaintext(n)
+		stored := encryptObject(t, "cbc", plain)
+
+		size, err := cbcPlaintextSize(block, int64(len(stored)), stored[len(stored)-2*aes.BlockSize:])
+		if err != nil || size != int64(n) {
+			t.Fatalf("size %d: plaintext size %d, %v", n, size, err)
+		}
+
+		for start := 0; start < n; start++ {
+			end := n - 1
+			storageStart, storageEnd := cbcStorageRange(int64(start), int64(end))
+			reader, err := NewCBCReader(bytes.NewReader(stored[storageStart:min(storageEnd+1, int64(len(stored)))]), block, int64(start))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(io.LimitReader(reader, int64(end-start+1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain[start:]) {
+				t.Fatalf("size %d offset %d does not match the plaintext", n, start)
+			}
+		}
+	}
+}
+
+func TestCBCInvalidPadding(t *testing.T) {
+	block := testAESBlock(t)
+	plain := testPlaintext(40)
+
+	for _, pad := range [][]byte{
+		bytes.Repeat([]byte{0}, aes.BlockSize),
+		bytes.Repeat([]byte{aes.BlockSize + 1}, aes.BlockSize),
+		append(bytes.Repeat([]byte{1}, aes.BlockSize-2), 3, 3),
+	} {
+		// a final block of bad padding behind the plaintext blocks
+		stored := encryptObject(t, "cbc", append(testPlaintext(2*aes.BlockSize), pad...))
+		stored = stored[:len(stored)-aes.BlockSize]
+		if _, err := cbcPlaintextSize(block, int64(len(stored)), stored[len(stored)-2*aes.BlockSize:]); err != errCBCPadding {
+			t.Errorf("padding %x: err %v, want %v", pad, err, errCBCPadding)
+		}
+
+		store := newMemStore()
+		store.put("bad.bin", stored, "application/octet-stream", nil)
+		w := serve(newTestServer(t, store, nil), http.MethodGet, "/cbc/bad.bin", nil)
+		if w.Code != http.StatusInternalServerError || w.Header().Get("Accept-Ranges") != "none" {
+			t.Errorf("padding %x: status %d, Accept-Ranges %q, want a corrupt object 500", pad, w.Code, w.Header().Get("Accept-Ranges"))
+		}
+	}
+
+	// not a whole number of blocks
+	store := newMemStore()
+	store.put("short.bin", encryptObject(t, "cbc", plain)[:40], "application/octet-stream", nil)
+	if w := serve(newTestServer(t, store, nil), http.MethodGet, "/cbc/short.bin", nil); w.Code != http.StatusInternalServerError {
+		t.Errorf("partial block: status %d, want 500", w.Code)
+	}
+}
@@ -0,0 +1,124 @@
+package s3fileserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// NewAESCipher returns the aes block cipher of a 16, 24 or 32 byte key.
+func NewAESCipher(key []byte) (cipher.Block, error) {
+	switch len(key) {
+	case 16, 24, 32:
+		return aes.NewCipher(key)
+	default:
+		return nil, fmt.Errorf("invalid aes key length %d bytes, must be 16, 24 or 32 bytes", len(key))
+	}
+}
+
+// seekCTR advances the counter block iv by the given number of blocks. The
+// counter is the whole iv read as a big-endian integer, which is how
+// cipher.NewCTR increments it, so the carry runs across every byte.
+func seekCTR(iv []byte, blocks uint64) {
+	carry := blocks
+	for i := len(iv) - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(iv[i]) + carry&0xff
+		iv[i] = byte(sum)
+		carry = carry>>8 + sum>>8
+	}
+}
+
+// minCTRBlockSize is the smallest cipher block ctr objects are accepted
+// with. The iv is one random block, and random ivs of a smaller block repeat
+// too soon across objects, reusing keystream.
+const minCTRBlockSize = 16
+
+// checkCTRBlock rejects a cipher whose block is too small for ctr.
+func checkCTRBlock(block cipher.Block) error {
+	if block.BlockSize() < minCTRBlockSize {
+		return fmt.Errorf("invalid ctr cipher block size %d bytes, must be at least %d bytes", block.BlockSize(), minCTRBlockSize)
+	}
+	return nil
+}
+
+type ctrReader struct {
+	stream cipher.Stream
+	reader io.Reader
+	iv     []byte
+}
+
+// NewCTRReader decrypts reader, which must be positioned at offset of the
+// ciphertext. The iv is one block of the cipher and is copied, so the same
+// iv can be shared between readers. A negative offset is rejected rather
+// than wrapped into a wrong counter. The counter is the whole iv, and like
+// cipher.NewCTR it wraps around to zero past an all ones block, so a writer
+// and a reader seeking into its output agree on every block.
+func NewCTRReader(reader io.Reader, block cipher.Block, iv []byte, offset int64) (*ctrReader, error) {
+	if err := checkCTRBlock(block); err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid ctr offset %d, must not be negative", offset)
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("invalid ctr iv length %d bytes, must be %d bytes", len(iv), block.BlockSize())
+	}
+
+	// calculate the initial counter value based on the IV and offset
+	blockSize := int64(block.BlockSize())
+	iv = append([]byte(nil), iv...)
+	seekCTR(iv, uint64(offset/blockSize))
+
+	stream := cipher.NewCTR(block, iv)
+
+	// advance the stream to the correct position within the block by
+	// discarding the key stream bytes before offset, always less than a block
+	if offsetWithinBlock := offset % blockSize; offsetWithinBlock > 0 {
+		skip := make([]byte, offsetWithinBlock)
+		stream.XORKeyStream(skip, skip)
+	}
+
+	return &ctrReader{stream: stream, reader: reader, iv: iv}, nil
+}
+
+func (r *ctrReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.stream.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+type ctrWriter struct {
+	stream cipher.Stream
+	writer io.Writer
+}
+
+// NewCTRWriter encrypts to writer with a random iv of one block, which is
+// written first.
+func NewCTRWriter(writer io.Writer, block cipher.Block) (*ctrWriter, error) {
+	if err := checkCTRBlock(block); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+
+	// the whole iv is the initial counter, see seekCTR
+	stream := cipher.NewCTR(block, iv)
+
+	// write the iv to the writer so it can be used for decryption
+	if _, err := writer.Write(iv); err != nil {
+		return nil, err
+	}
+
+	return &ctrWriter{stream: stream, writer: writer}, nil
+}
+
+func (w *ctrWriter) Write(p []byte) (int, error) {
+	cipher := make([]byte, len(p))
+	w.stream.XORKeyStream(cipher, p)
+
+	return w.writer.Write(cipher)
+}
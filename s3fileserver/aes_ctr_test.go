@@ -0,0 +1,350 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"math/big"
+	"net/http"
+	"testing"
+)
+
+func testAESBlock(t testing.TB) cipher.Block {
+	t.Helper()
+	block, err := NewAESCipher([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// testPlaintext returns n bytes that differ between neighbouring blocks.
+func testPlaintext(n int) []byte {
+	plain := make([]byte, n)
+	for i := range plain {
+		plain[i] = byte(i*7 + i/251)
+	}
+	return plain
+}
+
+func encryptCTR(t testing.TB, block cipher.Block, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewCTRWriter(&buf, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(bytes.Clone(plain)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestCTRRoundTripOffsets decrypts a range starting at every offset up to
+// 4096, including offsets within a block, and compares it to the plaintext.
+func TestCTRRoundTripOffsets(t *testing.T) {
+	block := testAESBlock(t)
+	plain := testPlaintext(10 << 10)
+	stored := encryptCTR(t, block, plain)
+	iv, ciphertext := stored[:block.BlockSize()], stored[block.BlockSize():]
+
+	for start := 0; start <= 4096; start++ {
+		// a range of varying length, ending within or at the end of a block
+		end := min(start+start%97+1, len(plain))
+		reader, err := NewCTRReader(bytes.NewReader(ciphertext[start:end]), block, iv, int64(start))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain[start:end]) {
+			t.Fatalf("range %d-%d does not match the plaintext", start, end-1)
+		}
+	}
+}
+
+// TestCTRRoundTripSmallReads reads through the decrypter a few bytes at a
+// time, so the key stream continues across reads.
+func TestCTRRoundTripSmallReads(t *testing.T) {
+	block := testAESBlock(t)
+	plain := testPlaintext(10 << 10)
+	stored := encryptCTR(t, block, plain)
+	iv, ciphertext := stored[:block.BlockSize()], stored[block.BlockSize():]
+
+	for _, start := range []int{0, 1, 15, 16, 17, 4095, 4096} {
+		reader, err := NewCTRReader(bytes.NewReader(ciphertext[start:]), block, iv, int64(start))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(&oneByteReader{reader})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain[start:]) {
+			t.Fatalf("offset %d does not match the plaintext", start)
+		}
+	}
+}
+
+// TestCTRCounterCarry seeks across a counter that overflows its low bytes,
+// cipher.NewCTR carries into the whole iv and so must seekCTR.
+func TestCTRCounterCarry(t *testing.T) {
+	block := testAESBlock(t)
+	iv := bytes.Repeat([]byte{0xff}, block.BlockSize())
+	iv[0] = 0x7f
+	plain := testPlaintext(1 << 10)
+
+	ciphertext := make([]byte, len(plain))
+	cipher.NewCTR(block, iv).XORKeyStream(ciphertext, plain)
+
+	for _, start := range []int{0, 15, 16, 17, 33, 1000} {
+		reader, err := NewCTRReader(bytes.NewReader(ciphertext[start:]), block, iv, int64(start))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain[start:]) {
+			t.Fatalf("offset %d does not match the plaintext", start)
+		}
+	}
+}
+
+func TestCTRReaderRejectsInvalidInput(t *testing.T) {
+	block := testAESBlock(t)
+	if _, err := NewCTRReader(bytes.NewReader(nil), block, make([]byte, 16), -1); err == nil {
+		t.Error("negative offset accepted")
+	}
+	if _, err := NewCTRReader(bytes.NewReader(nil), block, make([]byte, 8), 0); err == nil {
+		t.Error("short iv accepted")
+	}
+}
+
+// oneByteReader returns at most one byte per read.
+type oneByteReader struct {
+	reader io.Reader
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return r.reader.Read(p[:1])
+}
+
+// TestCTREmptyObject uploads an empty body, which is stored as the iv alone
+// and served back as an empty file without reading the object.
+func TestCTREmptyObject(t *testing.T) {
+	store := newMemStore()
+	h := newTestServer(t, store, nil)
+
+	if w := serve(h, http.MethodPut, "/ctr/empty.txt", bytes.NewReader(nil)); w.Code != http.StatusCreated {
+		t.Fatalf("upload status %d: %s", w.Code, w.Body)
+	}
+	obj, ok := store.object("bucket", "empty.txt")
+	if !ok {
+		t.Fatal("empty upload not stored")
+	}
+	if len(obj.data) != aes.BlockSize {
+		t.Fatalf("empty object stored as %d bytes, want the %d byte iv", len(obj.data), aes.BlockSize)
+	}
+
+	gets := store.getCount()
+	w := serve(h, http.MethodGet, "/ctr/empty.txt", nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "0" {
+		t.Errorf("got status %d, Content-Length %q and %d body bytes, want an empty 200", w.Code, w.Header().Get("Content-Length"), w.Body.Len())
+	}
+	if store.getCount() != gets {
+		t.Error("an empty object was read from s3")
+	}
+}
+
+// TestCTRSkipOffsets checks the in-block skip at every offset of the first
+// blocks, for each aes key size.
+func TestCTRSkipOffsets(t *testing.T) {
+	for _, keySize := range []int{16, 24, 32} {
+		block, err := NewAESCipher(testPlaintext(keySize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain := testPlaintext(4 * block.BlockSize())
+		stored := encryptCTR(t, block, plain)
+		iv, ciphertext := stored[:block.BlockSize()], stored[block.BlockSize():]
+
+		for offset := range len(plain) {
+			reader, err := NewCTRReader(bytes.NewReader(ciphertext[offset:offset+1]), block, iv, int64(offset))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil || len(got) != 1 || got[0] != plain[offset] {
+				t.Fatalf("key size %d offset %d: got %x, want %x, %v", keySize, offset, got, plain[offset], err)
+			}
+		}
+	}
+}
+
+// TestSeekCTR seeks by block counts that carry across several bytes and
+// compares with incrementing the counter one block at a time.
+func TestSeekCTR(t *testing.T) {
+	increment := func(iv []byte) {
+		for i := len(iv) - 1; i >= 0; i-- {
+			iv[i]++
+			if iv[i] != 0 {
+				return
+			}
+		}
+	}
+
+	start := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xf0}
+	want := bytes.Clone(start)
+	for blocks := uint64(0); blocks <= 0x20000; blocks++ {
+		got := bytes.Clone(start)
+		seekCTR(got, blocks)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%d blocks: got %x, want %x", blocks, got, want)
+		}
+		increment(want)
+	}
+
+	// the whole iv wraps around
+	iv := bytes.Repeat([]byte{0xff}, 16)
+	seekCTR(iv, 1)
+	if !bytes.Equal(iv, make([]byte, 16)) {
+		t.Errorf("wrapped iv %x", iv)
+	}
+}
+
+// fakeBlock is a cipher.Block of any block size, keyed by a single byte.
+type fakeBlock struct {
+	size int
+	key  byte
+}
+
+func (b fakeBlock) BlockSize() int { return b.size }
+
+func (b fakeBlock) Encrypt(dst, src []byte) {
+	for i := range b.size {
+		dst[i] = src[i] ^ b.key ^ byte(i)
+	}
+}
+
+func (b fakeBlock) Decrypt(dst, src []byte) { b.Encrypt(dst, src) }
+
+// TestCTRBlockSize takes the iv size from the cipher block, rejecting blocks
+// under 16 bytes and serving objects of a larger one.
+func TestCTRBlockSize(t *testing.T) {
+	small := fakeBlock{size: 8, key: 0x5a}
+	if _, err := NewCTRReader(bytes.NewReader(nil), small, make([]byte, 8), 0); err == nil {
+		t.Error("reader accepted an 8 byte block")
+	}
+	if _, err := NewCTRWriter(io.Discard, small); err == nil {
+		t.Error("writer accepted an 8 byte block")
+	}
+
+	large := fakeBlock{size: 32, key: 0xa5}
+	plain := testPlaintext(1000)
+	stored := encryptCTR(t, large, plain)
+	if len(stored) != 32+len(plain) {
+		t.Fatalf("stored %d bytes, want a 32 byte iv and the ciphertext", len(stored))
+	}
+
+	store := newMemStore()
+	store.put("a.bin", stored, "application/octet-stream", nil)
+	for _, block := range []cipher.Block{small, large} {
+		h := newTestServer(t, store, func(opts *serverOptions) {
+			opts.aesKeys = newKeyring("aes", defaultKeyID, map[string]cipher.Block{defaultKeyID: block})
+		})
+		w := serve(h, http.MethodGet, "/ctr/a.bin", nil, "Range", "bytes=40-99")
+		if block == small {
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("8 byte block: status %d, want 500", w.Code)
+			}
+			continue
+		}
+		if w.Code != http.StatusPartialContent || w.Header().Get("Content-Range") != "bytes 40-99/1000" || !bytes.Equal(w.Body.Bytes(), plain[40:100]) {
+			t.Errorf("32 byte block: status %d, Content-Range %q", w.Code, w.Header().Get("Content-Range"))
+		}
+	}
+}
+
+// TestCTRPast32BitCounter reads at offsets just before and after 2^32
+// blocks, where the count carries out of the low 32 bits of the counter,
+// and compares with cipher.NewCTR started at the block the offset is in.
+func TestCTRPast32BitCounter(t *testing.T) {
+	block := testAESBlock(t)
+	iv := []byte("an iv of a block")
+	plain := testPlaintext(64)
+
+	for _, blocks := range []uint64{1<<32 - 2, 1<<32 - 1, 1 << 32, 1<<32 + 1} {
+		for _, within := range []int64{0, 5} {
+			offset := int64(blocks)*aes.BlockSize + within
+
+			// the counter block computed independently of seekCTR
+			counter := new(big.Int).SetBytes(iv)
+			counter.Add(counter, new(big.Int).SetUint64(blocks))
+			start := counter.FillBytes(make([]byte, aes.BlockSize))
+
+			keystream := make([]byte, within+int64(len(plain)))
+			cipher.NewCTR(block, start).XORKeyStream(keystream, keystream)
+			ciphertext := make([]byte, len(plain))
+			for i := range plain {
+				ciphertext[i] = plain[i] ^ keystream[within+int64(i)]
+			}
+
+			reader, err := NewCTRReader(bytes.NewReader(ciphertext), block, iv, offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil || !bytes.Equal(got, plain) {
+				t.Errorf("%d blocks and %d bytes: plaintext does not round trip, %v", blocks, within, err)
+			}
+		}
+	}
+}
+
+// TestCTRCounterWrap seeks across an all ones counter, which cipher.NewCTR
+// wraps around to zero, at offsets before, at and after the wrap.
+func TestCTRCounterWrap(t *testing.T) {
+	block := testAESBlock(t)
+	iv := bytes.Repeat([]byte{0xff}, block.BlockSize())
+	iv[len(iv)-1] = 0xfd
+	plain := testPlaintext(8 * block.BlockSize())
+
+	ciphertext := make([]byte, len(plain))
+	cipher.NewCTR(block, iv).XORKeyStream(ciphertext, plain)
+
+	for _, start := range []int{0, 17, 32, 47, 48, 49, 64, 100} {
+		reader, err := NewCTRReader(bytes.NewReader(ciphertext[start:]), block, iv, int64(start))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(reader)
+		if err != nil || !bytes.Equal(got, plain[start:]) {
+			t.Errorf("offset %d does not match the plaintext, %v", start, err)
+		}
+	}
+}
+
+// TestCTRShortObject serves objects shorter than the iv as corrupt, with no
+// ranges to offer.
+func TestCTRShortObject(t *testing.T) {
+	store := newMemStore()
+	store.put("short.bin", encryptCTR(t, testAESBlock(t), nil)[:aes.BlockSize-1], "application/octet-stream", nil)
+	h := newTestServer(t, store, nil)
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		for _, rng := range []string{"", "bytes=0-0"} {
+			w := serve(h, method, "/ctr/short.bin", nil, "Range", rng)
+			if w.Code != http.StatusInternalServerError || w.Header().Get("Accept-Ranges") != "none" {
+				t.Errorf("%s with range %q: status %d, Accept-Ranges %q, want a corrupt object 500", method, rng, w.Code, w.Header().Get("Accept-Ranges"))
+			}
+		}
+	}
+}
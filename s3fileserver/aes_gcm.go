@@ -0,0 +1,227 @@
+package s3fileserver
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// A gcm object is laid out as an 8 byte random nonce prefix followed by
+// chunks of at most gcmChunkSize plaintext bytes, each sealed separately and
+// carrying its own tag. The nonce of a chunk is the prefix followed by the
+// big-endian chunk index, and the last chunk is sealed with a different
+// additional data byte so truncating the object at a chunk boundary is
+// detected as well.
+//
+// Range requests are mapped onto whole chunks: every chunk touched by the
+// range is fetched and authenticated, and the bytes outside the range are
+// dropped after decryption. A range therefore never splits a chunk in a way
+// that cannot be authenticated.
+const (
+	gcmChunkSize       = 64 * 1024
+	gcmNoncePrefixSize = 8
+	gcmTagSize         = 16
+	gcmSealedChunkSize = gcmChunkSize + gcmTagSize
+)
+
+var (
+	errGCMCorrupt = errors.New("gcm object is corrupt")
+	errGCMAuth    = errors.New("gcm chunk failed authentication")
+)
+
+// gcmPlaintextSize returns the plaintext size of a gcm object of the given
+// stored size.
+func gcmPlaintextSize(objectSize int64) (int64, error) {
+	n := objectSize - gcmNoncePrefixSize
+	if n < gcmTagSize {
+		return 0, errGCMCorrupt
+	}
+
+	chunks := (n + gcmSealedChunkSize - 1) / gcmSealedChunkSize
+	if n-(chunks-1)*gcmSealedChunkSize < gcmTagSize {
+		return 0, errGCMCorrupt
+	}
+
+	return n - chunks*gcmTagSize, nil
+}
+
+// gcmStorageRange returns the stored byte range holding every chunk needed
+// to decrypt the plaintext range start-end.
+func gcmStorageRange(start, end, objectSize int64) (int64, int64) {
+	storageStart := gcmNoncePrefixSize + (start/gcmChunkSize)*gcmSealedChunkSize
+	storageEnd := gcmNoncePrefixSize + (end/gcmChunkSize+1)*gcmSealedChunkSize - 1
+	if storageEnd >= objectSize {
+		storageEnd = objectSize - 1
+	}
+
+	return storageStart, storageEnd
+}
+
+func newGCM(block cipher.Block) (cipher.AEAD, error) {
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if aead.NonceSize() != gcmNoncePrefixSize+4 || aead.Overhead() != gcmTagSize {
+		return nil, errors.New("unsupported gcm parameters")
+	}
+
+	return aead, nil
+}
+
+func gcmNonce(prefix []byte, chunk uint32) []byte {
+	nonce := make([]byte, gcmNoncePrefixSize+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[gcmNoncePrefixSize:], chunk)
+	return nonce
+}
+
+func gcmAdditionalData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+type gcmReader struct {
+	aead      cipher.AEAD
+	reader    io.Reader
+	prefix    []byte
+	chunk     uint32
+	lastChunk uint32
+	skip      int
+	buf       []byte
+	plain     []byte
+}
+
+// NewGCMReader decrypts chunks read from reader, which must be positioned at
+// the start of the chunk holding offset. plaintextSize is the plaintext size
+// of the whole object and is used to recognise the final chunk.
+func NewGCMReader(reader io.Reader, block cipher.Block, prefix []byte, offset int64, plaintextSize int64) (*gcmReader, error) {
+	aead, err := newGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	lastChunk := int64(0)
+	if plaintextSize > 0 {
+		lastChunk = (plaintextSize - 1) / gcmChunkSize
+	}
+
+	return &gcmReader{
+		aead:      aead,
+		reader:    reader,
+		prefix:    prefix,
+		chunk:     uint32(offset / gcmChunkSize),
+		lastChunk: uint32(lastChunk),
+		skip:      int(offset % gcmChunkSize),
+		buf:       make([]byte, gcmSealedChunkSize),
+	}, nil
+}
+
+func (r *gcmReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.chunk > r.lastChunk {
+			return 0, io.EOF
+		}
+
+		final := r.chunk == r.lastChunk
+		n, err := io.ReadFull(r.reader, r.buf)
+		if err == io.EOF || (err == io.ErrUnexpectedEOF && !final) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+
+		plain, err := r.aead.Open(r.buf[:0], gcmNonce(r.prefix, r.chunk), r.buf[:n], gcmAdditionalData(final))
+		if err != nil {
+			return 0, errGCMAuth
+		}
+		r.chunk++
+
+		// drop the bytes before the requested offset in the first chunk
+		if r.skip > 0 {
+			if r.skip > len(plain) {
+				r.skip = len(plain)
+			}
+			plain = plain[r.skip:]
+			r.skip = 0
+		}
+		r.plain = plain
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+type gcmWriter struct {
+	aead   cipher.AEAD
+	writer io.Writer
+	prefix []byte
+	chunk  uint32
+	buf    []byte
+}
+
+// NewGCMWriter writes the nonce prefix to writer and returns a writer that
+// seals its input in chunks. Close must be called to seal the final chunk.
+func NewGCMWriter(writer io.Writer, block cipher.Block) (*gcmWriter, error) {
+	aead, err := newGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, gcmNoncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+
+	// write the nonce prefix to the writer so it can be used for decryption
+	if _, err := writer.Write(prefix); err != nil {
+		return nil, err
+	}
+
+	return &gcmWriter{
+		aead:   aead,
+		writer: writer,
+		prefix: prefix,
+		buf:    make([]byte, 0, gcmSealedChunkSize),
+	}, nil
+}
+
+func (w *gcmWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data shows it isn't the final one
+		if len(w.buf) == gcmChunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buf[len(w.buf):gcmChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (w *gcmWriter) Close() error {
+	return w.seal(true)
+}
+
+func (w *gcmWriter) seal(final bool) error {
+	sealed := w.aead.Seal(w.buf[:0], gcmNonce(w.prefix, w.chunk), w.buf, gcmAdditionalData(final))
+	if _, err := w.writer.Write(sealed); err != nil {
+		return err
+	}
+
+	w.chunk++
+	w.buf = w.buf[:0]
+	return nil
+}
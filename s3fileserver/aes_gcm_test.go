@@ -0,0 +1,109 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// gcmDecryptRange decrypts the plaintext range start-end of a stored gcm
+// object the way serveGCM does.
+func gcmDecryptRange(t *testing.T, stored []byte, start int64, end int64) ([]byte, error) {
+	t.Helper()
+	size, err := gcmPlaintextSize(int64(len(stored)))
+	if err != nil {
+		return nil, err
+	}
+	storageStart, storageEnd := gcmStorageRange(start, end, int64(len(stored)))
+	reader, err := NewGCMReader(bytes.NewReader(stored[storageStart:storageEnd+1]), testAESBlock(t), stored[:gcmNoncePrefixSize], start, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return io.ReadAll(io.LimitReader(reader, end-start+1))
+}
+
+// TestGCMChunkBoundaries round-trips plaintexts ending around a chunk
+// boundary and ranges starting and ending on either side of one.
+func TestGCMChunkBoundaries(t *testing.T) {
+	for _, n := range []int{1, gcmChunkSize - 1, gcmChunkSize, gcmChunkSize + 1, 2 * gcmChunkSize, 2*gcmChunkSize + 1} {
+		plain := testPlaintext(n)
+		stored := encryptObject(t, "gcm", plain)
+
+		size, err := gcmPlaintextSize(int64(len(stored)))
+		if err != nil || size != int64(n) {
+			t.Fatalf("size %d: plaintext size %d, %v", n, size, err)
+		}
+
+		for _, start := range []int{0, 1, gcmChunkSize - 1, gcmChunkSize, gcmChunkSize + 1, n - 1} {
+			for _, end := range []int{start, gcmChunkSize - 1, gcmChunkSize, 2*gcmChunkSize - 1, n - 1} {
+				if start >= n || end < start || end >= n {
+					continue
+				}
+				got, err := gcmDecryptRange(t, stored, int64(start), int64(end))
+				if err != nil {
+					t.Fatalf("size %d range %d-%d: %v", n, start, end, err)
+				}
+				if !bytes.Equal(got, plain[start:end+1]) {
+					t.Fatalf("size %d range %d-%d does not match the plaintext", n, start, end)
+				}
+			}
+		}
+	}
+}
+
+// TestGCMTruncatedAtChunkBoundary drops the final chunk, the chunk before it
+// was not sealed as the final one so the object does not authenticate.
+func TestGCMTruncatedAtChunkBoundary(t *testing.T) {
+	plain := testPlaintext(2*gcmChunkSize + 10)
+	stored := encryptObject(t, "gcm", plain)
+	truncated := stored[:gcmNoncePrefixSize+2*gcmSealedChunkSize]
+
+	if _, err := gcmDecryptRange(t, truncated, 0, 2*gcmChunkSize-1); err != errGCMAuth {
+		t.Errorf("truncated object: err %v, want %v", err, errGCMAuth)
+	}
+}
+
+func TestGCMTamperedChunk(t *testing.T) {
+	plain := testPlaintext(2 * gcmChunkSize)
+	stored := encryptObject(t, "gcm", plain)
+	stored[gcmNoncePrefixSize+gcmSealedChunkSize+5] ^= 1
+
+	// the first chunk still decrypts, the second does not
+	if _, err := gcmDecryptRange(t, stored, 0, gcmChunkSize-1); err != nil {
+		t.Errorf("untouched chunk: %v", err)
+	}
+	if _, err := gcmDecryptRange(t, stored, gcmChunkSize, gcmChunkSize); err != errGCMAuth {
+		t.Errorf("tampered chunk: err %v, want %v", err, errGCMAuth)
+	}
+}
+
+func TestGCMPlaintextSizeRejectsCorrupt(t *testing.T) {
+	// shorter than the prefix and one tag, or a last chunk shorter than a tag
+	for _, size := range []int64{0, gcmNoncePrefixSize + gcmTagSize - 1, gcmNoncePrefixSize + gcmSealedChunkSize + gcmTagSize - 1} {
+		if _, err := gcmPlaintextSize(size); err != errGCMCorrupt {
+			t.Errorf("size %d: err %v, want %v", size, err, errGCMCorrupt)
+		}
+	}
+}
+
+// TestGCMShortObject serves objects shorter than the nonce prefix, or than
+// the prefix and one tag, as corrupt, with no ranges to offer.
+func TestGCMShortObject(t *testing.T) {
+	stored := encryptObject(t, "gcm", nil)
+	store := newMemStore()
+	store.put("prefix.bin", stored[:gcmNoncePrefixSize-1], "application/octet-stream", nil)
+	store.put("tag.bin", stored[:gcmNoncePrefixSize+gcmTagSize-1], "application/octet-stream", nil)
+	h := newTestServer(t, store, nil)
+
+	for _, key := range []string{"prefix.bin", "tag.bin"} {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			for _, rng := range []string{"", "bytes=0-0"} {
+				w := serve(h, method, "/gcm/"+key, nil, "Range", rng)
+				if w.Code != http.StatusInternalServerError || w.Header().Get("Accept-Ranges") != "none" {
+					t.Errorf("%s %s with range %q: status %d, Accept-Ranges %q, want a corrupt object 500", method, key, rng, w.Code, w.Header().Get("Accept-Ranges"))
+				}
+			}
+		}
+	}
+}
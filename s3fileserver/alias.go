@@ -0,0 +1,58 @@
+package s3fileserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var errUnknownAlias = errors.New("unknown object alias")
+
+// keyAliases translates friendly url keys to the s3 keys they stand for.
+// Keys without an alias are used as they are, unless strict is set, then
+// they are rejected with errUnknownAlias.
+type keyAliases struct {
+	aliases map[string]string
+	strict  bool
+}
+
+// newKeyAliases parses mapping, a json object of alias to s3 key like
+// {"reports/latest.pdf":"a1/9f3c2e.bin"}. Both sides are keys below the key
+// prefix and must be valid object keys.
+func newKeyAliases(mapping string, strict bool) (keyAliases, error) {
+	aliases := keyAliases{strict: strict}
+	if mapping == "" {
+		return aliases, nil
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(mapping), &raw); err != nil {
+		return keyAliases{}, fmt.Errorf("expected a json object of alias to key, err: %v", err)
+	}
+
+	aliases.aliases = make(map[string]string, len(raw))
+	for alias, key := range raw {
+		name, err := sanitizeKey(alias)
+		if err != nil || name != alias {
+			return keyAliases{}, fmt.Errorf("invalid alias %q", alias)
+		}
+		target, err := sanitizeKey(key)
+		if err != nil || target != key {
+			return keyAliases{}, fmt.Errorf("invalid key %q of alias %q", key, alias)
+		}
+		aliases.aliases[alias] = key
+	}
+
+	return aliases, nil
+}
+
+// resolve returns the s3 key the url key stands for.
+func (a keyAliases) resolve(key string) (string, error) {
+	if target, ok := a.aliases[key]; ok {
+		return target, nil
+	}
+	if a.strict {
+		return "", errUnknownAlias
+	}
+	return key, nil
+}
@@ -0,0 +1,51 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestKeyAliases(t *testing.T) {
+	aliases, err := newKeyAliases(`{"reports/latest.pdf": "a1/9f3c2e.bin"}`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := aliases.resolve("reports/latest.pdf"); err != nil || got != "a1/9f3c2e.bin" {
+		t.Errorf("alias: got %q, %v", got, err)
+	}
+	if got, err := aliases.resolve("other.bin"); err != nil || got != "other.bin" {
+		t.Errorf("plain key: got %q, %v", got, err)
+	}
+
+	aliases.strict = true
+	if _, err := aliases.resolve("other.bin"); err != errUnknownAlias {
+		t.Errorf("strict plain key: err %v, want %v", err, errUnknownAlias)
+	}
+
+	for _, mapping := range []string{`["a"]`, `{"../a": "b"}`, `{"a": "/b"}`, `{"a//b": "c"}`, `{"a": ""}`} {
+		if _, err := newKeyAliases(mapping, false); err == nil {
+			t.Errorf("%s accepted", mapping)
+		}
+	}
+}
+
+// TestServeAlias serves the object an alias stands for, below the key
+// prefix, and a 404 for unknown keys in strict mode.
+func TestServeAlias(t *testing.T) {
+	plain := testPlaintext(100)
+	store := newMemStore()
+	store.put("tenant/a1/9f3c2e.bin", encryptObject(t, "xor", plain), "application/pdf", nil)
+	h := newTestServer(t, store, func(opts *serverOptions) {
+		opts.keyPrefix = "tenant"
+		opts.aliases, _ = newKeyAliases(`{"reports/latest.pdf": "a1/9f3c2e.bin"}`, true)
+	})
+
+	w := serve(h, http.MethodGet, "/xor/reports/latest.pdf", nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+		t.Errorf("alias: status %d", w.Code)
+	}
+	if w := serve(h, http.MethodGet, "/xor/a1/9f3c2e.bin", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unaliased key in strict mode: status %d, want 404", w.Code)
+	}
+}
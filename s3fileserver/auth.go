@@ -0,0 +1,187 @@
+package s3fileserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errSignatureInvalid = errors.New("invalid url signature")
+	errSignatureExpired = errors.New("url signature expired")
+	errSignatureMethod  = errors.New("signed urls only allow GET and HEAD requests")
+)
+
+// authenticator guards the server with api keys sent in the X-API-Key header
+// or as a bearer token, and with signed urls. Without any configured key or
+// signing key every request is allowed.
+type authenticator struct {
+	keyHashes   [][sha256.Size]byte
+	exemptPaths map[string]bool
+	signingKey  []byte
+	clockSkew   time.Duration
+}
+
+// newAuthenticator takes the comma separated API_KEYS and AUTH_EXEMPT_PATHS
+// values, and the URL_SIGNING_KEY with its allowed clock skew.
+func newAuthenticator(apiKeys string, exemptPaths string, signingKey string, clockSkew time.Duration) authenticator {
+	a := authenticator{exemptPaths: make(map[string]bool), clockSkew: clockSkew}
+	if signingKey != "" {
+		a.signingKey = []byte(signingKey)
+	}
+	for _, key := range strings.Split(apiKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			a.keyHashes = append(a.keyHashes, sha256.Sum256([]byte(key)))
+		}
+	}
+	for _, path := range strings.Split(exemptPaths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			a.exemptPaths[path] = true
+		}
+	}
+
+	return a
+}
+
+func (a authenticator) middleware(next http.Handler) http.Handler {
+	if len(a.keyHashes) == 0 && a.signingKey == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.exemptPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// a signed url grants access on its own
+		if a.signingKey != nil && r.URL.Query().Has("sig") {
+			if err := a.verifySignedRequest(r); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			ctx := context.WithValue(r.Context(), signedRequestKey{}, true)
+			if bps, err := strconv.ParseInt(r.URL.Query().Get("bps"), 10, 64); err == nil {
+				ctx = context.WithValue(ctx, bandwidthKey{}, bps)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if !a.validKey(requestAPIKey(r)) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validKey compares hashes so neither the key content nor its length leaks
+// through timing, and checks every configured key without returning early.
+func (a authenticator) validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	valid := 0
+	for _, keyHash := range a.keyHashes {
+		valid |= subtle.ConstantTimeCompare(hash[:], keyHash[:])
+	}
+
+	return valid == 1
+}
+
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	return ""
+}
+
+// verifySignedRequest checks the exp and sig query parameters of a signed
+// url. sig is the hex encoded HMAC-SHA256 of the request path and exp, the
+// expiry as a unix timestamp, joined by a newline, followed by another
+// newline and bps when the url grants a bandwidth. Expired urls are still
+// accepted within the configured clock skew. The method is not signed, so a
+// signed url only grants downloads, never uploads or deletes.
+func (a authenticator) verifySignedRequest(r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return errSignatureMethod
+	}
+
+	query := r.URL.Query()
+	exp := query.Get("exp")
+	expiry, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return errSignatureInvalid
+	}
+
+	// an optional bps parameter is signed along, so it cannot be added
+	// to a url signed without it
+	bps := query.Get("bps")
+	if bps != "" {
+		if n, err := strconv.ParseInt(bps, 10, 64); err != nil || n < 0 {
+			return errSignatureInvalid
+		}
+	}
+
+	sig, err := hex.DecodeString(query.Get("sig"))
+	if err != nil {
+		return errSignatureInvalid
+	}
+	if !hmac.Equal(sig, signURL(a.signingKey, signedHost(r.Host), r.URL.Path, exp, bps)) {
+		return errSignatureInvalid
+	}
+
+	if time.Now().After(time.Unix(expiry, 0).Add(a.clockSkew)) {
+		return errSignatureExpired
+	}
+
+	return nil
+}
+
+type signedRequestKey struct{}
+
+// signedRequest reports whether the request ctx belongs to was let in by a
+// valid url signature.
+func signedRequest(ctx context.Context) bool {
+	signed, _ := ctx.Value(signedRequestKey{}).(bool)
+	return signed
+}
+
+// signURL signs the host along with the path, buckets may be routed by host
+// and a url signed for one must not open the same path on another.
+func signURL(key []byte, host string, path string, exp string, bps string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(host + "\n" + path + "\n" + exp))
+	if bps != "" {
+		mac.Write([]byte("\n" + bps))
+	}
+	return mac.Sum(nil)
+}
+
+// signedHost returns the host a url is signed for, lower case and without
+// the port.
+func signedHost(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,124 @@
+package s3fileserver
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signedTarget(key string, path string, exp int64, bps string) string {
+	return tamper(key, path, exp, bps, func(*url.URL) {})
+}
+
+// tamper signs path, exp and bps for the host of test requests, example.com,
+// and then lets change alter the url.
+func tamper(key string, path string, exp int64, bps string, change func(u *url.URL)) string {
+	expiry := strconv.FormatInt(exp, 10)
+	query := url.Values{}
+	query.Set("exp", expiry)
+	query.Set("sig", hex.EncodeToString(signURL([]byte(key), "example.com", path, expiry, bps)))
+	if bps != "" {
+		query.Set("bps", bps)
+	}
+
+	u := &url.URL{Path: path, RawQuery: query.Encode()}
+	change(u)
+	return u.String()
+}
+
+// setHost returns a change sending the url to host.
+func setHost(host string) func(u *url.URL) {
+	return func(u *url.URL) {
+		u.Scheme = "http"
+		u.Host = host
+	}
+}
+
+// setQuery returns a change setting the query parameter name to value.
+func setQuery(name string, value string) func(u *url.URL) {
+	return func(u *url.URL) {
+		query := u.Query()
+		query.Set(name, value)
+		u.RawQuery = query.Encode()
+	}
+}
+
+func TestVerifySignedRequest(t *testing.T) {
+	a := newAuthenticator("", "", "secret", 30*time.Second)
+	future := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   error
+	}{
+		{"valid", http.MethodGet, signedTarget("secret", "/ctr/a.txt", future, ""), nil},
+		{"valid head", http.MethodHead, signedTarget("secret", "/ctr/a.txt", future, ""), nil},
+		{"valid with bandwidth", http.MethodGet, signedTarget("secret", "/ctr/a.txt", future, "1000"), nil},
+		{"within clock skew", http.MethodGet, signedTarget("secret", "/ctr/a.txt", time.Now().Add(-10*time.Second).Unix(), ""), nil},
+		{"expired", http.MethodGet, signedTarget("secret", "/ctr/a.txt", time.Now().Add(-time.Minute).Unix(), ""), errSignatureExpired},
+		{"wrong key", http.MethodGet, signedTarget("other", "/ctr/a.txt", future, ""), errSignatureInvalid},
+		{"tampered path", http.MethodGet, tamper("secret", "/ctr/a.txt", future, "", func(u *url.URL) { u.Path = "/ctr/b.txt" }), errSignatureInvalid},
+		{"tampered expiry", http.MethodGet, tamper("secret", "/ctr/a.txt", future, "", setQuery("exp", strconv.FormatInt(future+3600, 10))), errSignatureInvalid},
+		{"added bandwidth", http.MethodGet, tamper("secret", "/ctr/a.txt", future, "", setQuery("bps", "0")), errSignatureInvalid},
+		{"tampered bandwidth", http.MethodGet, tamper("secret", "/ctr/a.txt", future, "1000", setQuery("bps", "9999")), errSignatureInvalid},
+		{"removed bandwidth", http.MethodGet, tamper("secret", "/ctr/a.txt", future, "1000", setQuery("bps", "")), errSignatureInvalid},
+		{"same host with a port", http.MethodGet, tamper("secret", "/ctr/a.txt", future, "", setHost("EXAMPLE.com:8443")), nil},
+		{"other host", http.MethodGet, tamper("secret", "/ctr/a.txt", future, "", setHost("tenant-b.example.com")), errSignatureInvalid},
+		{"missing expiry", http.MethodGet, "/ctr/a.txt?sig=00", errSignatureInvalid},
+		{"put", http.MethodPut, signedTarget("secret", "/ctr/a.txt", future, ""), errSignatureMethod},
+		{"delete", http.MethodDelete, signedTarget("secret", "/file/a.txt", future, ""), errSignatureMethod},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if err := a.verifySignedRequest(r); !errors.Is(err, tt.want) {
+				t.Errorf("verifySignedRequest() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorMiddleware(t *testing.T) {
+	a := newAuthenticator("key1,key2", "/healthz", "secret", 0)
+	handler := a.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	future := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header map[string]string
+		want   int
+	}{
+		{"no credentials", http.MethodGet, "/ctr/a.txt", nil, http.StatusUnauthorized},
+		{"api key", http.MethodGet, "/ctr/a.txt", map[string]string{"X-API-Key": "key2"}, http.StatusNoContent},
+		{"bearer token", http.MethodGet, "/ctr/a.txt", map[string]string{"Authorization": "Bearer key1"}, http.StatusNoContent},
+		{"wrong api key", http.MethodGet, "/ctr/a.txt", map[string]string{"X-API-Key": "key3"}, http.StatusUnauthorized},
+		{"exempt path", http.MethodGet, "/healthz", nil, http.StatusNoContent},
+		{"signed url", http.MethodGet, signedTarget("secret", "/ctr/a.txt", future, ""), nil, http.StatusNoContent},
+		{"signed url put", http.MethodPut, signedTarget("secret", "/ctr/a.txt", future, ""), nil, http.StatusForbidden},
+		{"signed url delete", http.MethodDelete, signedTarget("secret", "/file/a.txt", future, ""), nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			for name, value := range tt.header {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
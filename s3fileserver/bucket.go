@@ -0,0 +1,101 @@
+package s3fileserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var errUnknownBucket = errors.New("unknown bucket")
+
+// bucketRouter picks the s3 bucket a request is served from. Without a
+// bucket map every request uses the default bucket. With one, the bucket is
+// named by the first label of the Host header, or by the first path segment
+// below the route when routing by path.
+type bucketRouter struct {
+	defaultBucket string
+	byPath        bool
+	buckets       map[string]string
+}
+
+// newBucketRouter parses bucketMap, a comma separated list of name=bucket
+// pairs. routing is "host" (the default) or "path".
+func newBucketRouter(defaultBucket string, bucketMap string, routing string) (bucketRouter, error) {
+	router := bucketRouter{defaultBucket: defaultBucket}
+
+	switch routing {
+	case "", "host":
+	case "path":
+		router.byPath = true
+	default:
+		return bucketRouter{}, fmt.Errorf("unknown bucket routing %q, must be host or path", routing)
+	}
+
+	for _, pair := range strings.Split(bucketMap, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		name, bucket, ok := strings.Cut(pair, "=")
+		name, bucket = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(bucket)
+		if !ok || name == "" || bucket == "" {
+			return bucketRouter{}, fmt.Errorf("invalid bucket mapping %q, expected name=bucket", pair)
+		}
+		if router.buckets == nil {
+			router.buckets = make(map[string]string)
+		}
+		router.buckets[name] = bucket
+	}
+
+	if router.buckets == nil && defaultBucket == "" {
+		return bucketRouter{}, errors.New("no bucket configured")
+	}
+	return router, nil
+}
+
+// route returns the bucket of the request and what is left of path, the
+// escaped request path below the route. Names missing from the map are
+// rejected with errUnknownBucket.
+func (b bucketRouter) route(r *http.Request, path string) (string, string, error) {
+	if b.buckets == nil {
+		return b.defaultBucket, path, nil
+	}
+
+	var name string
+	if b.byPath {
+		name, path, _ = strings.Cut(path, "/")
+	} else {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		name, _, _ = strings.Cut(host, ".")
+	}
+
+	bucket, ok := b.buckets[strings.ToLower(name)]
+	if !ok {
+		return "", "", errUnknownBucket
+	}
+	return bucket, path, nil
+}
+
+// all returns every bucket requests can be served from, sorted.
+func (b bucketRouter) all() []string {
+	if b.buckets == nil {
+		return []string{b.defaultBucket}
+	}
+
+	seen := make(map[string]bool)
+	var buckets []string
+	for _, bucket := range b.buckets {
+		if !seen[bucket] {
+			seen[bucket] = true
+			buckets = append(buckets, bucket)
+		}
+	}
+	sort.Strings(buckets)
+	return buckets
+}
@@ -0,0 +1,105 @@
+package s3fileserver
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ttlCache is a size bounded LRU cache whose entries also expire after a
+// fixed ttl. A nil cache is valid and never holds anything.
+type ttlCache[K comparable, V any] struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	ll         *list.List
+	items      map[K]*list.Element
+}
+
+type ttlCacheEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// newTTLCache returns nil when ttl or maxEntries is not positive, which
+// disables caching.
+func newTTLCache[K comparable, V any](ttl time.Duration, maxEntries int) *ttlCache[K, V] {
+	if ttl <= 0 || maxEntries <= 0 {
+		return nil
+	}
+
+	return &ttlCache[K, V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[K]*list.Element),
+	}
+}
+
+func (c *ttlCache[K, V]) Get(key K) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	entry := elem.Value.(*ttlCacheEntry[K, V])
+	if time.Now().After(entry.expires) {
+		c.removeElement(elem)
+		return zero, false
+	}
+
+	c.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *ttlCache[K, V]) Set(key K, value V) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*ttlCacheEntry[K, V])
+		entry.value = value
+		entry.expires = expires
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&ttlCacheEntry[K, V]{key: key, value: value, expires: expires})
+
+	// evict the least recently used entry
+	if c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *ttlCache[K, V]) Remove(key K) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *ttlCache[K, V]) removeElement(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*ttlCacheEntry[K, V]).key)
+}
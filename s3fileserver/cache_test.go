@@ -0,0 +1,120 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	c := newTTLCache[string, int](time.Hour, 2)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+
+	// b was the least recently used
+	if _, ok := c.Get("b"); ok {
+		t.Error("b not evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("a = %d, %v", v, ok)
+	}
+	c.Remove("a")
+	if _, ok := c.Get("a"); ok {
+		t.Error("a not removed")
+	}
+
+	expiring := newTTLCache[string, int](time.Nanosecond, 2)
+	expiring.Set("a", 1)
+	time.Sleep(time.Millisecond)
+	if _, ok := expiring.Get("a"); ok {
+		t.Error("expired entry returned")
+	}
+
+	// a disabled cache is nil and holds nothing
+	disabled := newTTLCache[string, int](0, 2)
+	disabled.Set("a", 1)
+	if _, ok := disabled.Get("a"); ok || disabled != nil {
+		t.Error("disabled cache holds an entry")
+	}
+}
+
+func TestContentCache(t *testing.T) {
+	c := newContentCache(10, 6)
+	a := contentCacheKey{objKey: "a", scheme: "xor"}
+	b := contentCacheKey{objKey: "b", scheme: "xor"}
+
+	if c.fits(7) || !c.fits(6) || c.fits(0) {
+		t.Error("fits does not bound the object size")
+	}
+
+	c.set(a, `"1"`, []byte("aaaaa"))
+	c.set(b, `"1"`, []byte("bbbbb"))
+	if data, ok := c.get(a, `"1"`); !ok || string(data) != "aaaaa" {
+		t.Errorf("a = %q, %v", data, ok)
+	}
+
+	// over the byte bound, b is the least recently used
+	c.set(contentCacheKey{objKey: "c"}, `"1"`, []byte("c"))
+	if _, ok := c.get(b, `"1"`); ok {
+		t.Error("b not evicted")
+	}
+
+	// another etag is a replaced object
+	if _, ok := c.get(a, `"2"`); ok {
+		t.Error("entry of another etag returned")
+	}
+	if _, ok := c.get(a, `"1"`); ok {
+		t.Error("stale entry not dropped")
+	}
+
+	if newContentCache(0, 6) != nil {
+		t.Error("cache of no bytes is not disabled")
+	}
+}
+
+// TestServerCaches serves repeated requests from the head, iv and content
+// caches instead of s3.
+func TestServerCaches(t *testing.T) {
+	plain := testPlaintext(1000)
+	store := newMemStore()
+	store.put("a.bin", encryptObject(t, "ctr", plain), "application/octet-stream", nil)
+	h := newTestServer(t, store, func(opts *serverOptions) {
+		opts.headCacheTTL = time.Minute
+		opts.headCacheSize = 10
+		opts.negativeCacheTTL = time.Minute
+		opts.negativeCacheSize = 10
+		opts.ivCacheSize = 10
+		opts.contentCacheBytes = 1 << 20
+		opts.contentCacheMaxObjectSize = 1 << 20
+	})
+
+	for i := 0; i < 3; i++ {
+		w := serve(h, http.MethodGet, "/ctr/a.bin", nil, "Range", "bytes=100-199")
+		if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), plain[100:200]) {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+	if store.heads != 1 || store.getCount() != 1 {
+		t.Errorf("%d heads and %d gets for three requests, want 1 and 1", store.heads, store.getCount())
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := serve(h, http.MethodGet, "/ctr/missing.bin", nil); w.Code != http.StatusNotFound {
+			t.Fatalf("missing object: status %d", w.Code)
+		}
+	}
+	if store.heads != 2 {
+		t.Errorf("missing object looked up %d times, want once", store.heads-1)
+	}
+
+	// an upload replaces the object and drops its cached metadata
+	if w := serve(h, http.MethodPut, "/ctr/a.bin", bytes.NewReader(plain[:10])); w.Code != http.StatusCreated {
+		t.Fatalf("upload status %d", w.Code)
+	}
+	if w := serve(h, http.MethodGet, "/ctr/a.bin", nil); !bytes.Equal(w.Body.Bytes(), plain[:10]) {
+		t.Errorf("replaced object served stale, status %d with %d bytes", w.Code, w.Body.Len())
+	}
+}
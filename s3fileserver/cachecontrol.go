@@ -0,0 +1,76 @@
+package s3fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// buildCacheControl returns the default Cache-Control value, empty when no
+// max age is configured. immutable tells clients the content behind a key
+// never changes, which only holds for content addressed keys.
+func buildCacheControl(maxAge time.Duration, immutable bool) string {
+	if maxAge <= 0 {
+		return ""
+	}
+
+	value := fmt.Sprintf("public, max-age=%d", int64(maxAge/time.Second))
+	if immutable {
+		value += ", immutable"
+	}
+	return value
+}
+
+// setCacheControl sets the Cache-Control header of an object, the
+// cache-control object tag overrides the configured default.
+func (h HTTPFileServer) setCacheControl(w http.ResponseWriter, tagMap map[string]string) {
+	value := h.cacheControl
+	if tag, ok := tagMap["cache-control"]; ok {
+		value = tag
+	}
+	if value != "" {
+		w.Header().Set("Cache-Control", value)
+	}
+}
+
+// cacheableResponses drops the Cache-Control header a handler set when the
+// response turns out to be an error, or a partial response unless
+// allowPartial is set.
+func cacheableResponses(allowPartial bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&cacheControlWriter{ResponseWriter: w, allowPartial: allowPartial}, r)
+	})
+}
+
+type cacheControlWriter struct {
+	http.ResponseWriter
+	allowPartial bool
+	wroteHeader  bool
+}
+
+func (w *cacheControlWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	switch {
+	case status == http.StatusOK, status == http.StatusNotModified:
+	case status == http.StatusPartialContent && w.allowPartial:
+	default:
+		w.Header().Del("Cache-Control")
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheControlWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,86 @@
+package s3fileserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ServeCBCFile serves a legacy object aes-cbc encrypted behind a 16 byte iv
+// with pkcs7 padding.
+func (h HTTPFileServer) ServeCBCFile(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/cbc/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	meta, tagMap, ok := h.objectInfo(w, r, bucket, objKey)
+	if !ok {
+		return
+	}
+
+	block, err := h.aesKeys.get(tagMap["key-id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.serveCBC(w, r, objKey, meta, tagMap, block)
+}
+
+func (h HTTPFileServer) serveCBC(w http.ResponseWriter, r *http.Request, objKey string, meta objectMeta, tagMap map[string]string, block cipher.Block) {
+	h.serveObject(w, r, objKey, meta, tagMap, objectFormat{
+		scheme: "cbc",
+		// an iv and at least one padded block, in whole blocks
+		plaintextSize: func(fileSize int64) (int64, error) {
+			if fileSize < 2*aes.BlockSize || fileSize%aes.BlockSize != 0 {
+				return 0, corruptObjectError("corrupt cbc object, not an iv and whole blocks")
+			}
+			return fileSize - aes.BlockSize, nil
+		},
+		// the plaintext size depends on the padding in the last block
+		exactSize: func() (int64, error) {
+			size, err := h.cbcPlaintextSize(r, objKey, meta, block)
+			if errors.Is(err, errCBCPadding) {
+				return 0, corruptObjectError("corrupt cbc object, invalid padding")
+			}
+			return size, err
+		},
+		// each range is read from the block before its start, which is the
+		// iv of the block holding the start
+		openPart: func(rng byteRange) (io.ReadCloser, error) {
+			storageStart, storageEnd := cbcStorageRange(rng.start, rng.end)
+			getObj, err := h.getRange(r.Context(), objKey, meta, storageStart, storageEnd)
+			if err != nil {
+				return nil, err
+			}
+			cbcReader, err := NewCBCReader(getObj.Body, block, rng.start)
+			if err != nil {
+				getObj.Body.Close()
+				return nil, err
+			}
+			return readCloser{io.LimitReader(cbcReader, rng.length()), getObj.Body}, nil
+		},
+	})
+}
+
+// cbcPlaintextSize reads the last two blocks of a cbc object to find the
+// size of its plaintext without the padding.
+func (h HTTPFileServer) cbcPlaintextSize(r *http.Request, objKey string, meta objectMeta, block cipher.Block) (int64, error) {
+	fileSize := meta.contentLength
+	tailObj, err := h.getRange(r.Context(), objKey, meta, fileSize-2*aes.BlockSize, fileSize-1)
+	if err != nil {
+		return 0, err
+	}
+	defer tailObj.Body.Close()
+
+	tail := make([]byte, 2*aes.BlockSize)
+	if _, err := io.ReadFull(tailObj.Body, tail); err != nil {
+		return 0, err
+	}
+
+	return cbcPlaintextSize(block, fileSize, tail)
+}
@@ -0,0 +1,99 @@
+package s3fileserver
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// A chacha object is laid out like a ctr object: the 12 byte random nonce
+// followed by the ciphertext. The keystream is produced in 64 byte blocks
+// and the block counter can be set directly, so any offset can be decrypted
+// without reading what comes before it.
+const (
+	chachaNonceSize = chacha20.NonceSize
+	chachaBlockSize = 64
+
+	// chachaMaxSize is the most a nonce can encrypt, the block counter is
+	// 32 bits so the keystream ends after 256 GiB
+	chachaMaxSize = (1 << 32) * chachaBlockSize
+)
+
+var errChaChaTooLarge = errors.New("chacha objects hold at most 256 GiB")
+
+type chachaReader struct {
+	stream *chacha20.Cipher
+	reader io.Reader
+}
+
+// NewChaChaReader decrypts reader, which must be positioned at offset of the
+// ciphertext. Offsets past the end of the keystream are rejected.
+func NewChaChaReader(reader io.Reader, key []byte, nonce []byte, offset int64) (*chachaReader, error) {
+	if offset < 0 || offset >= chachaMaxSize {
+		return nil, errChaChaTooLarge
+	}
+
+	stream, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// jump to the block holding the offset
+	stream.SetCounter(uint32(offset / chachaBlockSize))
+
+	// advance the stream to the correct position within the block
+	if offsetWithinBlock := offset % chachaBlockSize; offsetWithinBlock > 0 {
+		dummy := make([]byte, offsetWithinBlock)
+		stream.XORKeyStream(dummy, dummy)
+	}
+
+	return &chachaReader{stream: stream, reader: reader}, nil
+}
+
+func (r *chachaReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.stream.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+type chachaWriter struct {
+	stream  *chacha20.Cipher
+	writer  io.Writer
+	written int64
+}
+
+// NewChaChaWriter encrypts to writer with a random nonce, which is written
+// first. Writes past chachaMaxSize fail.
+func NewChaChaWriter(writer io.Writer, key []byte) (*chachaWriter, error) {
+	nonce := make([]byte, chachaNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	stream, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// write the nonce to the writer so it can be used for decryption
+	if _, err := writer.Write(nonce); err != nil {
+		return nil, err
+	}
+
+	return &chachaWriter{stream: stream, writer: writer}, nil
+}
+
+func (w *chachaWriter) Write(p []byte) (int, error) {
+	// the cipher panics once its counter overflows
+	if int64(len(p)) > chachaMaxSize-w.written {
+		return 0, errChaChaTooLarge
+	}
+	w.written += int64(len(p))
+
+	cipher := make([]byte, len(p))
+	w.stream.XORKeyStream(cipher, p)
+
+	return w.writer.Write(cipher)
+}
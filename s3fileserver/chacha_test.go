@@ -0,0 +1,61 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestChaChaCounterOffsets decrypts from offsets within and on either side
+// of block boundaries, where the reader sets the counter and skips into the
+// block.
+func TestChaChaCounterOffsets(t *testing.T) {
+	plain := testPlaintext(1000*chachaBlockSize + 100)
+	stored := encryptObject(t, "chacha", plain)
+	nonce, ciphertext := stored[:chachaNonceSize], stored[chachaNonceSize:]
+
+	offsets := []int{}
+	for i := 0; i <= 3*chachaBlockSize; i++ {
+		offsets = append(offsets, i)
+	}
+	for _, block := range []int{255, 256, 257, 999, 1000} {
+		offsets = append(offsets, block*chachaBlockSize-1, block*chachaBlockSize, block*chachaBlockSize+1)
+	}
+
+	for _, offset := range offsets {
+		reader, err := NewChaChaReader(bytes.NewReader(ciphertext[offset:]), testChaChaKey, nonce, int64(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(&oneByteReader{reader})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain[offset:]) {
+			t.Fatalf("offset %d does not match the plaintext", offset)
+		}
+	}
+}
+
+// TestChaChaKeystreamEnd rejects what the 32 bit block counter cannot
+// reach, instead of wrapping the counter around to the start.
+func TestChaChaKeystreamEnd(t *testing.T) {
+	nonce := make([]byte, chachaNonceSize)
+	for _, offset := range []int64{-1, chachaMaxSize, chachaMaxSize + chachaBlockSize} {
+		if _, err := NewChaChaReader(bytes.NewReader(nil), testChaChaKey, nonce, offset); err != errChaChaTooLarge {
+			t.Errorf("offset %d: err %v, want %v", offset, err, errChaChaTooLarge)
+		}
+	}
+	if _, err := NewChaChaReader(bytes.NewReader(nil), testChaChaKey, nonce, chachaMaxSize-1); err != nil {
+		t.Errorf("last byte of the keystream: %v", err)
+	}
+
+	w, err := NewChaChaWriter(io.Discard, testChaChaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.written = chachaMaxSize - 10
+	if _, err := w.Write(make([]byte, 11)); err != errChaChaTooLarge {
+		t.Errorf("write past the keystream: err %v, want %v", err, errChaChaTooLarge)
+	}
+}
@@ -0,0 +1,52 @@
+package s3fileserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// startupCheck validates the configured keys and the access to every
+// bucket, writing one line per check to out. It reports whether every
+// check passed. Keys are only checked when their scheme has any.
+func (h HTTPFileServer) startupCheck(ctx context.Context, out io.Writer) bool {
+	passed := true
+	report := func(name string, err error) {
+		if err != nil {
+			passed = false
+			fmt.Fprintf(out, "FAIL  %s: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(out, "ok    %s\n", name)
+	}
+
+	// a scheme without keys is skipped, the config has at least one
+	if h.xorKeys.len() != 0 {
+		_, err := h.xorKeys.get("")
+		report("xor key", err)
+	} else {
+		fmt.Fprintln(out, "skip  xor key: not configured")
+	}
+	if h.aesKeys.len() != 0 {
+		_, err := h.aesKeys.get("")
+		report("aes key", err)
+	} else {
+		fmt.Fprintln(out, "skip  aes key: not configured")
+	}
+	if h.chachaKeys.len() != 0 {
+		_, err := h.chachaKeys.get("")
+		report("chacha key", err)
+	} else {
+		fmt.Fprintln(out, "skip  chacha key: not configured")
+	}
+
+	// a wrong region, missing bucket or missing permission fails the head
+	for _, bucket := range h.buckets.all() {
+		ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		err := h.s3Client.Ping(ctx, bucket)
+		cancel()
+		report(fmt.Sprintf("bucket %s", bucket), err)
+	}
+
+	return passed
+}
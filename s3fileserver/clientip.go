@@ -0,0 +1,111 @@
+package s3fileserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// clientIPs resolves the address of the client behind the trusted proxies
+// and stores it in the request context.
+type clientIPs struct {
+	trusted []netip.Prefix
+}
+
+// newClientIPs parses a comma separated list of trusted proxy CIDRs, a bare
+// address trusts that single proxy.
+func newClientIPs(trustedProxies string) (clientIPs, error) {
+	var c clientIPs
+	for _, value := range strings.Split(trustedProxies, ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return clientIPs{}, fmt.Errorf("invalid trusted proxy %q, expected an ip or a cidr", value)
+			}
+			c.trusted = append(c.trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return clientIPs{}, fmt.Errorf("invalid trusted proxy %q, expected an ip or a cidr", value)
+		}
+		c.trusted = append(c.trusted, prefix.Masked())
+	}
+
+	return c, nil
+}
+
+func (c clientIPs) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r, c.trusted)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+// remoteIP returns the client address of the request ctx belongs to, or an
+// empty string.
+func remoteIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// clientIP returns the address of the client. The forwarding headers are
+// only believed when the direct peer is a trusted proxy, otherwise anyone
+// could claim any address. X-Forwarded-For is read from the right, skipping
+// trusted proxies, so addresses a client prepends itself are never used.
+// X-Real-IP is used when there is no X-Forwarded-For.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !isTrusted(addr, trusted) {
+		return peer
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return realIP.Unmap().String()
+		}
+		return peer
+	}
+
+	// every proxy appends the address it received the request from
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	client := addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+
+	return client.Unmap().String()
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
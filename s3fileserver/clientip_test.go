@@ -0,0 +1,63 @@
+package s3fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	c, err := newClientIPs("10.0.0.0/8, 192.168.1.1, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "", "203.0.113.7"},
+		{"spoofed forwarded for from an untrusted peer", "203.0.113.7:5000", []string{"1.1.1.1"}, "", "203.0.113.7"},
+		{"spoofed real ip from an untrusted peer", "203.0.113.7:5000", nil, "1.1.1.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.5:443", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"trusted proxy chain", "10.0.0.5:443", []string{"203.0.113.7, 10.1.1.1", "192.168.1.1"}, "", "203.0.113.7"},
+		{"client prepends an address", "10.0.0.5:443", []string{"1.1.1.1, 203.0.113.7, 10.1.1.1"}, "", "203.0.113.7"},
+		{"untrusted hop in the chain", "10.0.0.5:443", []string{"203.0.113.7, 198.51.100.9, 10.1.1.1"}, "", "198.51.100.9"},
+		{"only trusted hops", "10.0.0.5:443", []string{"10.2.2.2, 10.1.1.1"}, "", "10.2.2.2"},
+		{"garbage hop", "10.0.0.5:443", []string{"203.0.113.7, not-an-ip, 10.1.1.1"}, "", "10.1.1.1"},
+		{"real ip from a trusted proxy", "10.0.0.5:443", nil, "203.0.113.7", "203.0.113.7"},
+		{"invalid real ip", "10.0.0.5:443", nil, "nowhere", "10.0.0.5"},
+		{"ipv6 trusted proxy", "[fd00::1]:443", []string{"2001:db8::7"}, "", "2001:db8::7"},
+		{"ipv4 mapped hop", "10.0.0.5:443", []string{"::ffff:203.0.113.7"}, "", "203.0.113.7"},
+		{"no port", "203.0.113.7", []string{"1.1.1.1"}, "", "203.0.113.7"},
+		{"trusted without a port", "10.0.0.5", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"ipv6 without a port", "2001:db8::1", nil, "", "2001:db8::1"},
+		{"unparsable peer", "pipe", []string{"203.0.113.7"}, "", "pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ctr/a.txt", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := clientIP(r, c.trusted); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClientIPsRejectsInvalid(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/33", "proxy.example.com", "10.0.0"} {
+		if _, err := newClientIPs(value); err == nil {
+			t.Errorf("%q accepted", value)
+		}
+	}
+}
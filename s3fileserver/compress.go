@@ -0,0 +1,213 @@
+package s3fileserver
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// defaultCompressibleTypes are compressed when COMPRESSIBLE_TYPES is empty.
+const defaultCompressibleTypes = "text/*,application/json,application/xml,application/javascript,image/svg+xml"
+
+// compressedEncodings are the content codings responses are compressed
+// with, in order of preference when a client accepts several equally.
+var compressedEncodings = []string{"br", "gzip"}
+
+// encoder is a compressing writer that can be reused for another response.
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	"br": {
+		New: func() any {
+			return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+		},
+	},
+	"gzip": {
+		New: func() any {
+			return gzip.NewWriter(io.Discard)
+		},
+	},
+}
+
+// compressor compresses responses whose content type is in a configured
+// list with brotli or gzip, whichever the client prefers. Range requests are
+// never compressed, the ranges refer to the plain bytes.
+type compressor struct {
+	types []string
+}
+
+// newCompressor parses a comma separated list of media types, a type may
+// end in /* to match a whole family. "none" disables compression.
+func newCompressor(types string) compressor {
+	if types == "" {
+		types = defaultCompressibleTypes
+	}
+	if types == "none" {
+		return compressor{}
+	}
+
+	var c compressor
+	for _, t := range strings.Split(types, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			c.types = append(c.types, t)
+		}
+	}
+	return c
+}
+
+func (c compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range c.types {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c compressor) middleware(next http.Handler) http.Handler {
+	if len(c.types) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), compressedEncodings)
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, compressor: c, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding returns the supported coding an Accept-Encoding header
+// prefers, ties going to the earlier one in supported, or "" when the client
+// is best served the identity. A coding is accepted by name or by *, and a
+// zero quality value refuses it.
+func negotiateEncoding(header string, supported []string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			quality = q
+		}
+		qualities[coding] = quality
+	}
+
+	accepted := func(coding string) (float64, bool) {
+		if q, ok := qualities[coding]; ok {
+			return q, true
+		}
+		q, ok := qualities["*"]
+		return q, ok
+	}
+
+	best, bestQuality := "", 0.0
+	for _, coding := range supported {
+		if q, ok := accepted(coding); ok && q > bestQuality {
+			best, bestQuality = coding, q
+		}
+	}
+
+	// the identity is acceptable unless refused, and wins if preferred
+	if q, ok := accepted("identity"); ok && q > bestQuality {
+		return ""
+	}
+	return best
+}
+
+// compressResponseWriter decides when the header is written whether the
+// body is compressed, only full 200 responses of a compressible type are.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	compressor  compressor
+	encoding    string
+	enc         encoder
+	wroteHeader bool
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.Header()
+	if status == http.StatusOK && header.Get("Content-Encoding") == "" && w.compressor.compressible(header.Get("Content-Type")) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		header.Add("Vary", "Accept-Encoding")
+
+		// the compressed bytes are a different representation
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		w.enc = encoderPools[w.encoding].Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Close flushes the compressed stream, it must be called once the handler
+// has returned.
+func (w *compressResponseWriter) Close() error {
+	if w.enc == nil {
+		return nil
+	}
+
+	err := w.enc.Close()
+	encoderPools[w.encoding].Put(w.enc)
+	w.enc = nil
+	return err
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,146 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "gzip", want: "gzip"},
+		{header: "GZIP", want: "gzip"},
+		{header: "deflate", want: ""},
+		{header: "gzip, deflate, br", want: "br"},
+		{header: "gzip;q=1.0, br;q=0.5", want: "gzip"},
+		{header: "gzip;q=0.5, br;q=0.5", want: "br"},
+		{header: "gzip;q=0", want: ""},
+		{header: "gzip ; q=0.8", want: "gzip"},
+		{header: "gzip;q=2", want: ""},
+		{header: "gzip;q=abc", want: ""},
+		{header: "*", want: "br"},
+		{header: "*;q=0.5, br;q=0", want: "gzip"},
+		{header: "gzip;q=0.5, identity", want: ""},
+		{header: "gzip, identity;q=0", want: "gzip"},
+		{header: "identity;q=0.5, *;q=0.8", want: "br"},
+		{header: "gzip;q=0, br", want: "br"},
+		{header: "gzip;q=0.001", want: "gzip"},
+		{header: "br;q=0.0, gzip;q=0.000", want: ""},
+		// the examples of rfc 9110, section 12.5.3
+		{header: "compress, gzip", want: "gzip"},
+		{header: "compress;q=0.5, gzip;q=1.0", want: "gzip"},
+		{header: "gzip;q=1.0, identity; q=0.5, *;q=0", want: "gzip"},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header, compressedEncodings); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.header, got, tt.want)
+		}
+	}
+
+	if got := negotiateEncoding("gzip, br", []string{"gzip"}); got != "gzip" {
+		t.Errorf("gzip only server: got %q", got)
+	}
+}
+
+// TestCompressorGzip compresses a whole json file for a client accepting
+// gzip, but neither ranges nor types outside the list.
+func TestCompressorGzip(t *testing.T) {
+	plain := bytes.Repeat([]byte(`{"name": "value"}`), 1000)
+	store := newMemStore()
+	store.put("a.json", encryptObject(t, "xor", plain), "application/json", nil)
+	store.put("a.png", encryptObject(t, "xor", plain), "image/png", nil)
+	mux := http.NewServeMux()
+	newTestServer(t, store, nil).RegisterHandlers(mux)
+	handler := newCompressor("").middleware(mux)
+
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/xor/a.json", "Accept-Encoding", "gzip")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("status %d, Content-Encoding %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	if w.Header().Get("Content-Length") != "" || w.Header().Get("Vary") != "Accept-Encoding" || !strings.HasPrefix(w.Header().Get("ETag"), "W/") {
+		t.Errorf("headers %v", w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("decompressed body matches %v, %v", bytes.Equal(got, plain), err)
+	}
+
+	for _, w := range []*httptest.ResponseRecorder{
+		get("/xor/a.json"),
+		get("/xor/a.json", "Accept-Encoding", "gzip", "Range", "bytes=0-99"),
+		get("/xor/a.png", "Accept-Encoding", "gzip"),
+	} {
+		if w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("status %d compressed", w.Code)
+		}
+	}
+}
+
+// TestCompressorBrotli compresses with brotli when the client prefers it or
+// weighs both the same, and with gzip when it prefers gzip.
+func TestCompressorBrotli(t *testing.T) {
+	plain := bytes.Repeat([]byte(`{"name": "value"}`), 1000)
+	store := newMemStore()
+	store.put("a.json", encryptObject(t, "xor", plain), "application/json", nil)
+	mux := http.NewServeMux()
+	newTestServer(t, store, nil).RegisterHandlers(mux)
+	handler := newCompressor("").middleware(mux)
+
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "gzip, deflate, br", want: "br"},
+		{acceptEncoding: "br;q=0.9, gzip;q=1.0", want: "gzip"},
+		{acceptEncoding: "gzip;q=0, br", want: "br"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/xor/a.json", nil)
+		r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Encoding"); w.Code != http.StatusOK || got != tt.want {
+			t.Errorf("%q: status %d, Content-Encoding %q, want %q", tt.acceptEncoding, w.Code, got, tt.want)
+			continue
+		}
+
+		var body io.Reader = brotli.NewReader(w.Body)
+		if tt.want == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = zr
+		}
+		got, err := io.ReadAll(body)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("%q: decompressed body matches %v, %v", tt.acceptEncoding, bytes.Equal(got, plain), err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package s3fileserver
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// checkPreconditions evaluates the conditional request headers against the
+// object's validators in the order given by RFC 9110 section 13.2.2. It
+// writes the response and returns false when the request must stop here.
+// The date conditions are ignored when the last modified time is unknown.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	// get if match request header, it takes precedence over if unmodified since
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" {
+		if !etagMatch(ifMatch, etag, false) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return false
+		}
+	}
+
+	// get if unmodified since request header
+	ifUnmodifiedSince := r.Header.Get("If-Unmodified-Since")
+	if ifUnmodifiedSince != "" && ifMatch == "" && !lastModified.IsZero() {
+		parseTime, err := time.Parse(http.TimeFormat, ifUnmodifiedSince)
+		if err != nil {
+			http.Error(w, "invalid If-Unmodified-Since header", http.StatusBadRequest)
+			return false
+		}
+		if lastModified.After(parseTime) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return false
+		}
+	}
+
+	// get if none match request header, it takes precedence over if modified since
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch != "" {
+		if etagMatch(ifNoneMatch, etag, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return false
+		}
+		return true
+	}
+
+	// get if modified since request header
+	ifModifiedSince := r.Header.Get("If-Modified-Since")
+	if ifModifiedSince != "" && !lastModified.IsZero() {
+		parseTime, err := time.Parse(http.TimeFormat, ifModifiedSince)
+		if err != nil {
+			http.Error(w, "invalid If-Modified-Since header", http.StatusBadRequest)
+			return false
+		}
+		if !lastModified.After(parseTime) {
+			w.WriteHeader(http.StatusNotModified)
+			return false
+		}
+	}
+
+	return true
+}
+
+// etagMatch reports whether any entity tag in the comma separated header
+// matches etag. The "*" wildcard matches any existing object. Weak comparison
+// ignores the W/ prefix, strong comparison never matches a weak tag.
+func etagMatch(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if etag == "" {
+			continue
+		}
+
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if !strings.HasPrefix(candidate, "W/") && !strings.HasPrefix(etag, "W/") && candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rangeHeader returns the Range request header, or an empty string when an
+// If-Range condition shows the object has changed since the client got its
+// first part, in which case the whole file must be served instead. An entity
+// tag is compared strongly and a date must equal the last modified time.
+func rangeHeader(r *http.Request, etag string, lastModified time.Time) string {
+	requestedRange := r.Header.Get("Range")
+	ifRange := r.Header.Get("If-Range")
+	if requestedRange == "" || ifRange == "" {
+		return requestedRange
+	}
+
+	// a weak entity tag never matches, the ranges need a strong validator
+	if strings.HasPrefix(ifRange, "W/") {
+		return ""
+	}
+
+	// an entity tag is always quoted, anything else is a date
+	if strings.HasPrefix(ifRange, `"`) {
+		if !etagMatch(ifRange, etag, false) {
+			return ""
+		}
+		return requestedRange
+	}
+
+	parseTime, err := time.Parse(http.TimeFormat, ifRange)
+	if err != nil || lastModified.IsZero() || !lastModified.Truncate(time.Second).Equal(parseTime) {
+		return ""
+	}
+	return requestedRange
+}
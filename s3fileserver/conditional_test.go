@@ -0,0 +1,125 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	etag := `"abc"`
+	before := testLastModified.Add(-time.Hour).Format(http.TimeFormat)
+	at := testLastModified.Format(http.TimeFormat)
+	after := testLastModified.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		header []string
+		// status is 0 when the request goes on
+		status int
+	}{
+		{name: "none"},
+		{name: "if-match", header: []string{"If-Match", `"abc"`}},
+		{name: "if-match list", header: []string{"If-Match", `"x", "abc"`}},
+		{name: "if-match star", header: []string{"If-Match", "*"}},
+		{name: "if-match other", header: []string{"If-Match", `"x"`}, status: http.StatusPreconditionFailed},
+		{name: "if-match weak", header: []string{"If-Match", `W/"abc"`}, status: http.StatusPreconditionFailed},
+		{name: "if-unmodified-since after", header: []string{"If-Unmodified-Since", after}},
+		{name: "if-unmodified-since at", header: []string{"If-Unmodified-Since", at}},
+		{name: "if-unmodified-since before", header: []string{"If-Unmodified-Since", before}, status: http.StatusPreconditionFailed},
+		{name: "if-unmodified-since invalid", header: []string{"If-Unmodified-Since", "yesterday"}, status: http.StatusBadRequest},
+		{name: "if-match wins over if-unmodified-since", header: []string{"If-Match", `"abc"`, "If-Unmodified-Since", before}},
+		{name: "if-none-match", header: []string{"If-None-Match", `"abc"`}, status: http.StatusNotModified},
+		{name: "if-none-match weak", header: []string{"If-None-Match", `W/"abc"`}, status: http.StatusNotModified},
+		{name: "if-none-match star", header: []string{"If-None-Match", "*"}, status: http.StatusNotModified},
+		{name: "if-none-match other", header: []string{"If-None-Match", `"x"`}},
+		{name: "if-modified-since at", header: []string{"If-Modified-Since", at}, status: http.StatusNotModified},
+		{name: "if-modified-since after", header: []string{"If-Modified-Since", after}, status: http.StatusNotModified},
+		{name: "if-modified-since before", header: []string{"If-Modified-Since", before}},
+		{name: "if-modified-since invalid", header: []string{"If-Modified-Since", "yesterday"}, status: http.StatusBadRequest},
+		{name: "if-none-match wins over if-modified-since", header: []string{"If-None-Match", `"x"`, "If-Modified-Since", at}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/xor/a.bin", nil)
+		for i := 0; i+1 < len(tt.header); i += 2 {
+			r.Header.Set(tt.header[i], tt.header[i+1])
+		}
+		w := httptest.NewRecorder()
+
+		ok := checkPreconditions(w, r, etag, testLastModified)
+		if tt.status == 0 {
+			if !ok {
+				t.Errorf("%s: stopped with %d, want the request to go on", tt.name, w.Code)
+			}
+			continue
+		}
+		if ok || w.Code != tt.status {
+			t.Errorf("%s: ok %v with status %d, want %d", tt.name, ok, w.Code, tt.status)
+		}
+	}
+}
+
+// TestCheckPreconditionsUnknownDate ignores the date conditions of an
+// object without a last modified time.
+func TestCheckPreconditionsUnknownDate(t *testing.T) {
+	for _, name := range []string{"If-Modified-Since", "If-Unmodified-Since"} {
+		r := httptest.NewRequest(http.MethodGet, "/xor/a.bin", nil)
+		r.Header.Set(name, testLastModified.Format(http.TimeFormat))
+		if !checkPreconditions(httptest.NewRecorder(), r, `"abc"`, time.Time{}) {
+			t.Errorf("%s applied without a last modified time", name)
+		}
+	}
+}
+
+func TestRangeHeader(t *testing.T) {
+	etag := `"abc"`
+	tests := []struct {
+		name    string
+		ifRange string
+		want    string
+	}{
+		{name: "no if-range", want: "bytes=0-9"},
+		{name: "same etag", ifRange: `"abc"`, want: "bytes=0-9"},
+		{name: "other etag", ifRange: `"x"`},
+		{name: "weak etag", ifRange: `W/"abc"`},
+		{name: "same date", ifRange: testLastModified.Format(http.TimeFormat), want: "bytes=0-9"},
+		{name: "later date", ifRange: testLastModified.Add(time.Second).Format(http.TimeFormat)},
+		{name: "earlier date", ifRange: testLastModified.Add(-time.Second).Format(http.TimeFormat)},
+		{name: "invalid date", ifRange: "yesterday"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/xor/a.bin", nil)
+		r.Header.Set("Range", "bytes=0-9")
+		if tt.ifRange != "" {
+			r.Header.Set("If-Range", tt.ifRange)
+		}
+		if got := rangeHeader(r, etag, testLastModified); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestServeIfRange serves the range while the object is unchanged and the
+// whole file once it was replaced.
+func TestServeIfRange(t *testing.T) {
+	plain := testPlaintext(100)
+	store := newMemStore()
+	store.put("a.bin", encryptObject(t, "ctr", plain), "application/octet-stream", nil)
+	h := newTestServer(t, store, nil)
+
+	etag := serve(h, http.MethodHead, "/ctr/a.bin", nil).Header().Get("ETag")
+	w := serve(h, http.MethodGet, "/ctr/a.bin", nil, "Range", "bytes=10-19", "If-Range", etag)
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), plain[10:20]) {
+		t.Errorf("unchanged object: status %d", w.Code)
+	}
+
+	store.put("a.bin", encryptObject(t, "ctr", plain[:50]), "application/octet-stream", nil)
+	w = serve(h, http.MethodGet, "/ctr/a.bin", nil, "Range", "bytes=10-19", "If-Range", etag)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain[:50]) || w.Header().Get("Content-Range") != "" {
+		t.Errorf("replaced object: status %d, Content-Range %q", w.Code, w.Header().Get("Content-Range"))
+	}
+}
@@ -0,0 +1,361 @@
+package s3fileserver
+
+import (
+	"crypto/cipher"
+	"log/slog"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// downloadEndpoints are the endpoints whose downloads can be capped on their
+// own, e.g. with MAX_DOWNLOAD_SIZE_XOR.
+var downloadEndpoints = []string{"xor", "ctr", "gcm", "chacha", "cbc", "raw", "file"}
+
+// Config is the configuration of the s3-file-server command, read from the
+// environment by LoadConfig. example.env lists every variable.
+type Config struct {
+	// server
+	ListenAddr        string
+	H2C               bool
+	LogLevel          slog.Level
+	StartupCheck      bool
+	ShutdownTimeout   time.Duration
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	IdleTimeout       time.Duration
+	HandlerTimeout    time.Duration
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSCertBase64     string
+	TLSKeyBase64      string
+
+	// s3
+	AWSAccessKey       string
+	AWSAccessSecret    string
+	AWSRegion          string
+	S3Bucket           string
+	BucketMap          string
+	BucketRouting      string
+	S3Accelerate       bool
+	S3Endpoint         string
+	S3PathStyle        bool
+	S3Timeout          time.Duration
+	S3ReadRetries      int
+	ExposeS3RequestIDs bool
+
+	// the xor and aes keys are kept encoded, keys read from their secret
+	// arns are decoded the same way once fetched
+	XORKey           string
+	XORKeys          string
+	XORKeyEncoding   string
+	XORDefaultKeyID  string
+	XORKeySecretARN  string
+	AESKey           string
+	AESKeyEncoding   string
+	AESKeySecretARN  string
+	KeySecretRefresh time.Duration
+
+	// the chacha key is optional, objects with an hmac tag are verified
+	// when the hmac key is set, and the sse-c key is sent to s3 with every
+	// read and write
+	ChaChaKey         []byte
+	HMACKey           []byte
+	HMACStrictMaxSize int64
+	SSECustomerKey    []byte
+
+	// objects
+	DefaultEncryption   string
+	KeyPrefix           string
+	KeyAliases          string
+	KeyAliasesStrict    bool
+	ContentTypeMap      string
+	ContentTypeForce    bool
+	ListMaxKeys         int
+	ListAllowedPrefixes []string
+	AllowDelete         bool
+	AllowUploads        bool
+	PresignExpiry       time.Duration
+	MaxUploadSize       int64
+
+	// downloads, MaxDownloadSizes holds the limits of single endpoints
+	HeadCacheTTL                time.Duration
+	HeadCacheSize               int
+	NegativeCacheTTL            time.Duration
+	NegativeCacheSize           int
+	IVCacheSize                 int
+	ContentCacheBytes           int64
+	ContentCacheMaxObjectSize   int64
+	CopyBufferSize              int
+	ParallelParts               int
+	ParallelPartSize            int64
+	CTRInlineThreshold          int64
+	MaxDownloadSize             int64
+	MaxDownloadSizes            map[string]int64
+	MaxDownloadSizeExemptSigned bool
+	CacheMaxAge                 time.Duration
+	CacheImmutable              bool
+	CachePartial                bool
+	CompressibleTypes           string
+	ThumbnailMaxSize            int64
+	ThumbnailMaxPixels          int64
+
+	// limits
+	MaxConcurrent        int
+	MaxBytesPerSec       int64
+	RateLimitRPS         float64
+	RateLimitBurst       int
+	RateLimitBytesPerSec int64
+
+	// access
+	APIKeys         string
+	AuthExemptPaths string
+	URLSigningKey   string
+	URLSigningSkew  time.Duration
+	TrustedProxies  string
+	AllowedOrigins  string
+
+	// parsed from the settings above by LoadConfig, which validates them
+	buckets      bucketRouter
+	contentTypes contentTypeResolver
+	aliases      keyAliases
+	clientIPs    clientIPs
+}
+
+// ConfigError lists every problem found in the configuration.
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// LoadConfig reads the configuration from the environment. It checks all of
+// it and reports every problem at once in a ConfigError, rather than
+// stopping at the first one.
+func LoadConfig() (Config, error) {
+	env := &envReader{}
+	cfg := Config{
+		ListenAddr:        env.string("LISTEN_ADDR", ":8080"),
+		H2C:               env.bool("H2C"),
+		StartupCheck:      env.bool("STARTUP_CHECK"),
+		ShutdownTimeout:   env.duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		ReadHeaderTimeout: env.duration("READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       env.duration("READ_TIMEOUT", 0),
+		IdleTimeout:       env.duration("IDLE_TIMEOUT", 2*time.Minute),
+		HandlerTimeout:    env.duration("HANDLER_TIMEOUT", 0),
+		TLSCertFile:       env.string("TLS_CERT_FILE", ""),
+		TLSKeyFile:        env.string("TLS_KEY_FILE", ""),
+		TLSCertBase64:     env.string("TLS_CERT_BASE64", ""),
+		TLSKeyBase64:      env.string("TLS_KEY_BASE64", ""),
+
+		AWSAccessKey:       env.string("AWS_ACCESS_KEY", ""),
+		AWSAccessSecret:    env.string("AWS_ACCESS_SECRET", ""),
+		AWSRegion:          env.string("AWS_REGION", ""),
+		S3Bucket:           env.string("S3_BUCKET", ""),
+		BucketMap:          env.string("BUCKET_MAP", ""),
+		BucketRouting:      env.string("BUCKET_ROUTING", ""),
+		S3Accelerate:       env.bool("S3_ACCELERATE"),
+		S3Endpoint:         env.string("S3_ENDPOINT", ""),
+		S3PathStyle:        env.bool("S3_PATH_STYLE"),
+		S3Timeout:          env.duration("S3_TIMEOUT", 0),
+		S3ReadRetries:      env.int("S3_READ_RETRIES", 3),
+		ExposeS3RequestIDs: env.bool("EXPOSE_S3_REQUEST_ID"),
+
+		XORKey:           env.string("XOR_KEY", ""),
+		XORKeys:          env.string("XOR_KEYS", ""),
+		XORKeyEncoding:   env.string("XOR_KEY_ENCODING", ""),
+		XORDefaultKeyID:  env.string("XOR_DEFAULT_KEY_ID", defaultKeyID),
+		XORKeySecretARN:  env.string("XOR_KEY_SECRET_ARN", ""),
+		AESKey:           env.string("AES_KEY", ""),
+		AESKeyEncoding:   env.string("AES_KEY_ENCODING", ""),
+		AESKeySecretARN:  env.string("AES_KEY_SECRET_ARN", ""),
+		KeySecretRefresh: env.duration("KEY_SECRET_REFRESH", 0),
+
+		ChaChaKey:         env.key("CHACHA_KEY"),
+		HMACKey:           env.key("HMAC_KEY"),
+		HMACStrictMaxSize: env.int64("HMAC_STRICT_MAX_SIZE", 0),
+		SSECustomerKey:    env.key("SSE_C_KEY"),
+
+		DefaultEncryption:   env.string("DEFAULT_ENCRYPTION", "none"),
+		KeyPrefix:           env.string("KEY_PREFIX", ""),
+		KeyAliases:          env.string("KEY_ALIASES", ""),
+		KeyAliasesStrict:    env.bool("KEY_ALIASES_STRICT"),
+		ContentTypeMap:      env.string("CONTENT_TYPE_MAP", ""),
+		ContentTypeForce:    env.bool("CONTENT_TYPE_FORCE"),
+		ListMaxKeys:         env.int("LIST_MAX_KEYS", 1000),
+		ListAllowedPrefixes: env.list("LIST_ALLOWED_PREFIXES"),
+		AllowDelete:         env.bool("ALLOW_DELETE"),
+		AllowUploads:        env.bool("ALLOW_UPLOADS"),
+		PresignExpiry:       env.duration("PRESIGN_EXPIRY", 15*time.Minute),
+		MaxUploadSize:       env.int64("MAX_UPLOAD_SIZE", 1<<30),
+
+		HeadCacheTTL:                env.duration("HEAD_CACHE_TTL", 0),
+		HeadCacheSize:               env.int("HEAD_CACHE_SIZE", 1024),
+		NegativeCacheTTL:            env.duration("NEGATIVE_CACHE_TTL", 0),
+		NegativeCacheSize:           env.int("NEGATIVE_CACHE_SIZE", 4096),
+		IVCacheSize:                 env.int("IV_CACHE_SIZE", 4096),
+		ContentCacheBytes:           env.int64("CONTENT_CACHE_BYTES", 0),
+		ContentCacheMaxObjectSize:   env.int64("CONTENT_CACHE_MAX_OBJECT_SIZE", 1<<20),
+		CopyBufferSize:              env.int("COPY_BUFFER_SIZE", defaultCopyBufferSize),
+		ParallelParts:               env.int("PARALLEL_PARTS", 0),
+		ParallelPartSize:            env.int64("PARALLEL_PART_SIZE", 8<<20),
+		CTRInlineThreshold:          env.int64("CTR_INLINE_THRESHOLD", 1<<20),
+		MaxDownloadSize:             env.int64("MAX_DOWNLOAD_SIZE", 0),
+		MaxDownloadSizes:            map[string]int64{},
+		MaxDownloadSizeExemptSigned: env.bool("MAX_DOWNLOAD_SIZE_EXEMPT_SIGNED"),
+		CacheMaxAge:                 env.duration("CACHE_MAX_AGE", 0),
+		CacheImmutable:              env.bool("CACHE_IMMUTABLE"),
+		CachePartial:                env.bool("CACHE_PARTIAL"),
+		CompressibleTypes:           env.string("COMPRESSIBLE_TYPES", ""),
+		ThumbnailMaxSize:            env.int64("THUMBNAIL_MAX_SIZE", defaultThumbnailMaxSize),
+		ThumbnailMaxPixels:          env.int64("THUMBNAIL_MAX_PIXELS", defaultThumbnailMaxPixels),
+
+		MaxConcurrent:        env.int("MAX_CONCURRENT", 0),
+		MaxBytesPerSec:       env.int64("MAX_BYTES_PER_SEC", 0),
+		RateLimitRPS:         env.float("RATE_LIMIT_RPS", 0),
+		RateLimitBurst:       env.int("RATE_LIMIT_BURST", 0),
+		RateLimitBytesPerSec: env.int64("RATE_LIMIT_BYTES_PER_SEC", 0),
+
+		APIKeys:         env.string("API_KEYS", ""),
+		AuthExemptPaths: env.string("AUTH_EXEMPT_PATHS", "/health,/healthz,/readyz"),
+		URLSigningKey:   env.string("URL_SIGNING_KEY", ""),
+		URLSigningSkew:  env.duration("URL_SIGNING_SKEW", 30*time.Second),
+		TrustedProxies:  env.string("TRUSTED_PROXIES", ""),
+		AllowedOrigins:  env.string("ALLOWED_ORIGINS", ""),
+	}
+	for _, endpoint := range downloadEndpoints {
+		name := "MAX_DOWNLOAD_SIZE_" + strings.ToUpper(endpoint)
+		if env.string(name, "") != "" {
+			cfg.MaxDownloadSizes[endpoint] = env.int64(name, 0)
+		}
+	}
+	if err := cfg.LogLevel.UnmarshalText([]byte(env.string("LOG_LEVEL", "info"))); err != nil {
+		env.problem("invalid LOG_LEVEL, err: %v", err)
+	}
+
+	if cfg.AWSRegion == "" {
+		env.problem("missing AWS_REGION")
+	}
+	var err error
+	if cfg.S3Bucket == "" && cfg.BucketMap == "" {
+		env.problem("missing S3_BUCKET")
+	} else if cfg.buckets, err = newBucketRouter(cfg.S3Bucket, cfg.BucketMap, cfg.BucketRouting); err != nil {
+		env.problem("invalid BUCKET_MAP, err: %v", err)
+	}
+	// signed urls only grant downloads, so uploads need api keys to be guarded
+	if cfg.AllowUploads && len(newAuthenticator(cfg.APIKeys, "", "", 0).keyHashes) == 0 {
+		env.problem("ALLOW_UPLOADS requires API_KEYS, uploads would be open to anyone")
+	}
+	if cfg.S3Accelerate && cfg.S3Endpoint != "" {
+		env.problem("S3_ACCELERATE and S3_ENDPOINT cannot be used together")
+	}
+	if err := validateListenAddr(cfg.ListenAddr); err != nil {
+		env.problem("invalid LISTEN_ADDR %q, expected host:port, err: %v", cfg.ListenAddr, err)
+	}
+	if cfg.CopyBufferSize <= 0 {
+		env.problem("invalid COPY_BUFFER_SIZE %d, must be positive", cfg.CopyBufferSize)
+	}
+	if cfg.ThumbnailMaxSize <= 0 {
+		env.problem("invalid THUMBNAIL_MAX_SIZE %d, must be positive", cfg.ThumbnailMaxSize)
+	}
+	if cfg.ThumbnailMaxPixels <= 0 {
+		env.problem("invalid THUMBNAIL_MAX_PIXELS %d, must be positive", cfg.ThumbnailMaxPixels)
+	}
+	if cfg.ListMaxKeys <= 0 {
+		env.problem("invalid LIST_MAX_KEYS %d, must be positive", cfg.ListMaxKeys)
+	}
+	if !validEncryption(cfg.DefaultEncryption) {
+		env.problem("invalid DEFAULT_ENCRYPTION %q, must be none, xor, ctr, gcm, chacha or cbc", cfg.DefaultEncryption)
+	}
+	if cfg.contentTypes, err = newContentTypeResolver(cfg.ContentTypeMap, cfg.ContentTypeForce); err != nil {
+		env.problem("invalid CONTENT_TYPE_MAP, err: %v", err)
+	}
+	if cfg.aliases, err = newKeyAliases(cfg.KeyAliases, cfg.KeyAliasesStrict); err != nil {
+		env.problem("invalid KEY_ALIASES, err: %v", err)
+	}
+	if cfg.clientIPs, err = newClientIPs(cfg.TrustedProxies); err != nil {
+		env.problem("invalid TRUSTED_PROXIES, err: %v", err)
+	}
+
+	// keys read from a secret are checked once they are fetched
+	if cfg.XORKeySecretARN == "" {
+		if _, err := loadXORKeys(cfg.XORKey, cfg.XORKeys, cfg.XORKeyEncoding, cfg.XORDefaultKeyID); err != nil {
+			env.problem("failed to load xor keys, err: %v", err)
+		}
+	}
+	if cfg.AESKeySecretARN == "" {
+		if _, err := loadAESKeys(cfg.AESKey, cfg.AESKeyEncoding); err != nil {
+			env.problem("%v", err)
+		}
+	}
+	if len(cfg.ChaChaKey) != 0 && len(cfg.ChaChaKey) != chacha20.KeySize {
+		env.problem("invalid CHACHA_KEY length %d bytes, must be %d bytes", len(cfg.ChaChaKey), chacha20.KeySize)
+	}
+	if len(cfg.SSECustomerKey) != 0 && len(cfg.SSECustomerKey) != 32 {
+		env.problem("invalid SSE_C_KEY length %d bytes, must be 32 bytes", len(cfg.SSECustomerKey))
+	}
+
+	// every endpoint but /raw/ needs a key, so a server without any is
+	// certainly misconfigured
+	if !cfg.hasKey() {
+		env.problem("missing a key, set XOR_KEY, XOR_KEYS, AES_KEY or CHACHA_KEY")
+	}
+
+	if len(env.problems) != 0 {
+		return Config{}, env.problems
+	}
+	return cfg, nil
+}
+
+// serverOptions returns the file server settings of a config read by
+// LoadConfig, serving the keys of the given keyrings.
+func (c Config) serverOptions(xorKeys keyring[[]byte], aesKeys keyring[cipher.Block]) serverOptions {
+	chachaKeys := map[string][]byte{}
+	if len(c.ChaChaKey) != 0 {
+		chachaKeys[defaultKeyID] = c.ChaChaKey
+	}
+
+	return serverOptions{
+		buckets:           c.buckets,
+		xorKeys:           xorKeys,
+		aesKeys:           aesKeys,
+		chachaKeys:        newKeyring("chacha", defaultKeyID, chachaKeys),
+		defaultEncryption: c.DefaultEncryption,
+
+		headCacheTTL:              c.HeadCacheTTL,
+		headCacheSize:             c.HeadCacheSize,
+		negativeCacheTTL:          c.NegativeCacheTTL,
+		negativeCacheSize:         c.NegativeCacheSize,
+		ivCacheSize:               c.IVCacheSize,
+		contentCacheBytes:         c.ContentCacheBytes,
+		contentCacheMaxObjectSize: c.ContentCacheMaxObjectSize,
+
+		copyBufferSize:     c.CopyBufferSize,
+		exposeS3RequestIDs: c.ExposeS3RequestIDs,
+		hmacKey:            c.HMACKey,
+		hmacStrictMaxSize:  c.HMACStrictMaxSize,
+		parallelParts:      c.ParallelParts,
+		parallelPartSize:   c.ParallelPartSize,
+		ctrInlineMax:       c.CTRInlineThreshold,
+		readRetries:        c.S3ReadRetries,
+		cacheControl:       buildCacheControl(c.CacheMaxAge, c.CacheImmutable),
+		contentTypes:       c.contentTypes,
+		downloadLimit:      newDownloadLimit(c.MaxDownloadSize, c.MaxDownloadSizes, c.MaxDownloadSizeExemptSigned),
+		thumbnails:         newThumbnailLimits(c.ThumbnailMaxSize, c.ThumbnailMaxPixels),
+
+		keyPrefix:     c.KeyPrefix,
+		aliases:       c.aliases,
+		listMaxKeys:   c.ListMaxKeys,
+		listPrefixes:  c.ListAllowedPrefixes,
+		allowDelete:   c.AllowDelete,
+		presignExpiry: c.PresignExpiry,
+		maxUploadSize: c.MaxUploadSize,
+	}
+}
+
+// hasKey reports whether any encryption key is configured.
+func (c Config) hasKey() bool {
+	return c.XORKey != "" || c.XORKeys != "" || c.XORKeySecretARN != "" ||
+		c.AESKey != "" || c.AESKeySecretARN != "" || len(c.ChaChaKey) != 0
+}
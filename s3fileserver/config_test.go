@@ -0,0 +1,261 @@
+package s3fileserver
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// setEnv sets the environment of a test, on top of a minimal valid
+// configuration.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	base := map[string]string{
+		"AWS_REGION": "us-east-1",
+		"S3_BUCKET":  "bucket",
+		"XOR_KEY":    "secretkey",
+	}
+	for name, value := range env {
+		base[name] = value
+	}
+	for name, value := range base {
+		t.Setenv(name, value)
+	}
+}
+
+func TestConfigBuildsServer(t *testing.T) {
+	setEnv(t, map[string]string{
+		"AES_KEY":              "0123456789abcdef0123456789abcdef",
+		"BUCKET_MAP":           "media=media-bucket",
+		"CONTENT_TYPE_MAP":     "mkv=video/x-matroska",
+		"KEY_ALIASES":          `{"latest": "releases/v2.zip"}`,
+		"COPY_BUFFER_SIZE":     "4096",
+		"EXPOSE_S3_REQUEST_ID": "1",
+		"HEAD_CACHE_TTL":       "1m",
+		"CACHE_MAX_AGE":        "1h",
+		"LIST_MAX_KEYS":        "50",
+		"KEY_PREFIX":           "tenant",
+		"PARALLEL_PARTS":       "4",
+		"S3_READ_RETRIES":      "5",
+	})
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := newKeySource(nil, cfg)
+	xorKeys, aesKeys, err := keys.load(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHTTPFileServer(nil, cfg.serverOptions(newKeyring("xor", cfg.XORDefaultKeyID, xorKeys), newKeyring("aes", defaultKeyID, aesKeys)))
+
+	if h.xorKeys.len() != 1 || h.aesKeys.len() != 1 || h.chachaKeys.len() != 0 {
+		t.Errorf("keyrings hold %d xor, %d aes and %d chacha keys, want 1, 1 and 0", h.xorKeys.len(), h.aesKeys.len(), h.chachaKeys.len())
+	}
+	if got := len(*h.buffers.pool.Get().(*[]byte)); got != 4096 {
+		t.Errorf("copy buffer size = %d, want 4096", got)
+	}
+	if !h.exposeS3RequestIDs {
+		t.Error("s3 request ids not exposed")
+	}
+	if h.headCache == nil || h.missCache != nil || h.contentCache != nil {
+		t.Error("only the head cache should be enabled")
+	}
+	if h.cacheControl != "public, max-age=3600" {
+		t.Errorf("cache control = %q", h.cacheControl)
+	}
+	if h.listMaxKeys != 50 || h.parallelParts != 4 || h.readRetries != 5 || h.keyPrefix != "tenant/" {
+		t.Errorf("got list max keys %d, parallel parts %d, read retries %d, key prefix %q", h.listMaxKeys, h.parallelParts, h.readRetries, h.keyPrefix)
+	}
+	if got := h.contentTypes.resolve("a.mkv", ""); got != "video/x-matroska" {
+		t.Errorf("content type of a.mkv = %q", got)
+	}
+	if got, err := h.aliases.resolve("latest"); err != nil || got != "releases/v2.zip" {
+		t.Errorf("alias latest = %q, %v", got, err)
+	}
+}
+
+func TestNewHTTPFileServerDefaults(t *testing.T) {
+	h, err := NewHTTPFileServer(nil, "bucket", []byte("secretkey"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.xorKeys.len() != 1 || h.aesKeys.len() != 0 || h.chachaKeys.len() != 0 {
+		t.Errorf("keyrings hold %d xor, %d aes and %d chacha keys, want 1, 0 and 0", h.xorKeys.len(), h.aesKeys.len(), h.chachaKeys.len())
+	}
+	if h.defaultEncryption != "none" || h.listMaxKeys != 1000 || h.presignExpiry != 15*time.Minute || h.maxUploadSize != 1<<30 {
+		t.Errorf("unexpected defaults %+v", h)
+	}
+	if h.headCache != nil || h.missCache != nil || h.ivCache != nil || h.contentCache != nil {
+		t.Error("caches should be off")
+	}
+	if got := len(*h.buffers.pool.Get().(*[]byte)); got != defaultCopyBufferSize {
+		t.Errorf("copy buffer size = %d, want %d", got, defaultCopyBufferSize)
+	}
+
+	if _, err := NewHTTPFileServer(nil, "bucket", nil, []byte("short")); err == nil {
+		t.Error("invalid aes key accepted")
+	}
+	if _, err := NewHTTPFileServer(nil, "", nil, nil); err == nil {
+		t.Error("missing bucket accepted")
+	}
+}
+
+// TestLoadConfigWithoutDotEnv starts from the environment alone, as in a
+// container without a .env file. The bucket, the region and a key are
+// still required.
+func TestLoadConfigWithoutDotEnv(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := godotenv.Load(); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("loading a missing .env: err %v, want %v", err, fs.ErrNotExist)
+	}
+
+	setEnv(t, nil)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.S3Bucket != "bucket" || cfg.AWSRegion != "us-east-1" || cfg.XORKey != "secretkey" {
+		t.Errorf("bucket %q, region %q, xor key %q", cfg.S3Bucket, cfg.AWSRegion, cfg.XORKey)
+	}
+
+	setEnv(t, map[string]string{"AWS_REGION": "", "S3_BUCKET": "", "XOR_KEY": ""})
+	_, err = LoadConfig()
+	for _, problem := range []string{"missing AWS_REGION", "missing S3_BUCKET", "missing a key"} {
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("err %v, want it to report %q", err, problem)
+		}
+	}
+}
+
+// TestLoadConfigProblems reports every problem of a configuration in one
+// ConfigError, in the order they are checked.
+func TestLoadConfigProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{name: "valid"},
+		{
+			name: "missing region and bucket",
+			env:  map[string]string{"AWS_REGION": "", "S3_BUCKET": ""},
+			want: []string{"missing AWS_REGION", "missing S3_BUCKET"},
+		},
+		{
+			name: "bucket map instead of a bucket",
+			env:  map[string]string{"S3_BUCKET": "", "BUCKET_MAP": "media=media-bucket"},
+		},
+		{
+			name: "missing key",
+			env:  map[string]string{"XOR_KEY": ""},
+			want: []string{"missing a key, set XOR_KEY, XOR_KEYS, AES_KEY or CHACHA_KEY"},
+		},
+		{
+			name: "key from a secret",
+			env:  map[string]string{"XOR_KEY": "", "AES_KEY_SECRET_ARN": "arn:aws:secretsmanager:us-east-1:1:secret:aes"},
+		},
+		{
+			name: "missing bucket and a short aes key",
+			env:  map[string]string{"S3_BUCKET": "", "AES_KEY": "short"},
+			want: []string{"missing S3_BUCKET", "invalid aes key length 5 bytes, must be 16, 24 or 32 bytes"},
+		},
+		{
+			name: "everything missing and unparsable numbers",
+			env:  map[string]string{"AWS_REGION": "", "S3_BUCKET": "", "XOR_KEY": "", "LIST_MAX_KEYS": "many", "S3_TIMEOUT": "soon"},
+			want: []string{"invalid S3_TIMEOUT", "invalid LIST_MAX_KEYS", "missing AWS_REGION", "missing S3_BUCKET", "missing a key"},
+		},
+		{
+			name: "short chacha key",
+			env:  map[string]string{"CHACHA_KEY": "short"},
+			want: []string{"invalid CHACHA_KEY length 5 bytes, must be 32 bytes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+			_, err := LoadConfig()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var problems ConfigError
+			if !errors.As(err, &problems) || len(problems) != len(tt.want) {
+				t.Fatalf("err %v, want %d problems", err, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("problem %d is %q, want %q", i, problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+// TestUploadsGuarded mounts no uploads by default, so an unauthenticated PUT
+// is refused, and only allows uploads behind api keys.
+func TestUploadsGuarded(t *testing.T) {
+	// the handler chain of Run, down to the auth middleware
+	handler := func(cfg Config) http.Handler {
+		mux := http.NewServeMux()
+		newTestServer(t, newMemStore(), nil).RegisterHandlers(mux, WithUploads(cfg.AllowUploads))
+		return newAuthenticator(cfg.APIKeys, cfg.AuthExemptPaths, cfg.URLSigningKey, cfg.URLSigningSkew).middleware(mux)
+	}
+	put := func(h http.Handler, header ...string) int {
+		r := httptest.NewRequest(http.MethodPut, "/xor/a.bin", strings.NewReader("data"))
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	setEnv(t, nil)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AllowUploads {
+		t.Error("uploads allowed by default")
+	}
+	if status := put(handler(cfg)); status != http.StatusMethodNotAllowed {
+		t.Errorf("default config: status %d, want 405", status)
+	}
+
+	for _, env := range []map[string]string{
+		{"ALLOW_UPLOADS": "1"},
+		{"ALLOW_UPLOADS": "1", "API_KEYS": " , "},
+		{"ALLOW_UPLOADS": "1", "URL_SIGNING_KEY": "signing key"},
+	} {
+		setEnv(t, env)
+		if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "ALLOW_UPLOADS requires API_KEYS") {
+			t.Errorf("%v: err %v", env, err)
+		}
+	}
+
+	setEnv(t, map[string]string{"ALLOW_UPLOADS": "1", "API_KEYS": "key"})
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := handler(cfg)
+	if status := put(h); status != http.StatusUnauthorized {
+		t.Errorf("without a key: status %d, want 401", status)
+	}
+	if status := put(h, "X-API-Key", "key"); status != http.StatusCreated {
+		t.Errorf("with a key: status %d, want 201", status)
+	}
+}
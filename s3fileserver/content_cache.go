@@ -0,0 +1,146 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"sync"
+)
+
+// contentCache is an LRU cache of decrypted objects bounded by their total
+// size. Entries are checked against the etag of the object, so a replaced
+// object is never served from the cache. A nil cache is valid and never holds
+// anything.
+type contentCache struct {
+	mu            sync.Mutex
+	maxBytes      int64
+	maxObjectSize int64
+	size          int64
+	ll            *list.List
+	items         map[contentCacheKey]*list.Element
+}
+
+// contentCacheKey names the plaintext of an object version, the same object
+// decrypts differently behind each endpoint.
+type contentCacheKey struct {
+	bucket    string
+	objKey    string
+	versionID string
+	scheme    string
+}
+
+type contentCacheEntry struct {
+	key  contentCacheKey
+	etag string
+	data []byte
+}
+
+// newContentCache returns nil when maxBytes is not positive, which disables
+// caching. Objects larger than maxObjectSize are never cached.
+func newContentCache(maxBytes int64, maxObjectSize int64) *contentCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+
+	return &contentCache{
+		maxBytes:      maxBytes,
+		maxObjectSize: min(maxObjectSize, maxBytes),
+		ll:            list.New(),
+		items:         make(map[contentCacheKey]*list.Element),
+	}
+}
+
+// fits reports whether an object of size may be cached.
+func (c *contentCache) fits(size int64) bool {
+	return c != nil && size > 0 && size <= c.maxObjectSize
+}
+
+// get returns the cached plaintext of key, an entry of another etag is
+// dropped.
+func (c *contentCache) get(key contentCacheKey, etag string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*contentCacheEntry)
+	if entry.etag != etag {
+		c.removeElement(elem)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(elem)
+	return entry.data, true
+}
+
+func (c *contentCache) set(key contentCacheKey, etag string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+	c.items[key] = c.ll.PushFront(&contentCacheEntry{key: key, etag: etag, data: data})
+	c.size += int64(len(data))
+
+	// evict the least recently used entries
+	for c.size > c.maxBytes {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *contentCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*contentCacheEntry)
+	c.ll.Remove(elem)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.data))
+}
+
+// cachedParts returns openPart reading from the content cache. The first
+// range read of an uncached object loads its whole plaintext into the cache,
+// objects too large for it are read through openPart as before.
+func (h HTTPFileServer) cachedParts(objKey string, meta objectMeta, scheme string, size int64, openPart func(rng byteRange) (io.ReadCloser, error)) func(rng byteRange) (io.ReadCloser, error) {
+	// without an etag a changed object could not be told apart
+	if !h.contentCache.fits(size) || meta.etag == "" {
+		return openPart
+	}
+
+	key := contentCacheKey{bucket: meta.bucket, objKey: objKey, versionID: meta.versionID, scheme: scheme}
+	var (
+		once sync.Once
+		data []byte
+		err  error
+	)
+	load := func() {
+		cached, ok := h.contentCache.get(key, meta.etag)
+		observeCacheLookup("content", ok)
+		if ok {
+			data = cached
+			return
+		}
+
+		var reader io.ReadCloser
+		reader, err = openPart(byteRange{start: 0, end: size - 1})
+		if err != nil {
+			return
+		}
+		defer reader.Close()
+
+		data = make([]byte, size)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return
+		}
+		h.contentCache.set(key, meta.etag, data)
+	}
+
+	return func(rng byteRange) (io.ReadCloser, error) {
+		once.Do(load)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data[rng.start : rng.end+1])), nil
+	}
+}
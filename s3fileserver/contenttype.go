@@ -0,0 +1,114 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffLength is the number of bytes http.DetectContentType looks at.
+const sniffLength = 512
+
+// contentTypeResolver picks the content type objects are served with. The
+// type stored in s3 is used unless it is missing or generic, then the type
+// is inferred from the key's extension, from the configured map first and
+// the system mime types second. With force set, a known extension wins over
+// any stored type.
+type contentTypeResolver struct {
+	types map[string]string
+	force bool
+}
+
+// newContentTypeResolver parses mapping, a comma separated list of
+// extension=type pairs like "mkv=video/x-matroska,.m4a=audio/mp4".
+func newContentTypeResolver(mapping string, force bool) (contentTypeResolver, error) {
+	resolver := contentTypeResolver{types: make(map[string]string), force: force}
+
+	for _, pair := range strings.Split(mapping, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		ext, contentType, ok := strings.Cut(pair, "=")
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		contentType = strings.TrimSpace(contentType)
+		if !ok || ext == "" || contentType == "" {
+			return contentTypeResolver{}, fmt.Errorf("invalid content type mapping %q, expected extension=type", pair)
+		}
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return contentTypeResolver{}, fmt.Errorf("invalid content type %q, err: %v", contentType, err)
+		}
+		resolver.types["."+ext] = contentType
+	}
+
+	return resolver, nil
+}
+
+// resolve returns the content type of objKey, stored is the type s3 has.
+func (c contentTypeResolver) resolve(objKey string, stored string) string {
+	if stored != "" && !genericContentType(stored) && !c.force {
+		return stored
+	}
+
+	ext := strings.ToLower(path.Ext(objKey))
+	if contentType, ok := c.types[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); ext != "" && contentType != "" {
+		return contentType
+	}
+
+	if stored == "" {
+		return "application/octet-stream"
+	}
+	return stored
+}
+
+// genericContentType reports whether s3 holds no real type for an object,
+// the defaults s3 clients store for an unknown type.
+func genericContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+
+	return mediaType == "application/octet-stream" || mediaType == "binary/octet-stream"
+}
+
+// sniffable reports whether a body starting at offset start is typed by its
+// first bytes, which only objects without a real type are. Objects stored
+// compressed would be typed as gzip.
+func sniffable(contentType string, tagMap map[string]string, start int64) bool {
+	return start == 0 && genericContentType(contentType) && tagMap["content-encoding"] == ""
+}
+
+// sniffContentType detects the type of a decrypted body from its first
+// bytes, and returns the body with those bytes put back in front.
+func sniffContentType(body io.Reader) (string, io.Reader, error) {
+	buf := make([]byte, sniffLength)
+	n, err := io.ReadFull(body, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+
+	buf = buf[:n]
+	return http.DetectContentType(buf), io.MultiReader(bytes.NewReader(buf), body), nil
+}
+
+// sniffObject detects the type of an object of the given plaintext size from
+// its first bytes, read with openPart, for a head request to send the type a
+// get of the object would.
+func sniffObject(size int64, openPart func(rng byteRange) (io.ReadCloser, error)) (string, error) {
+	reader, err := openPart(byteRange{start: 0, end: min(size, sniffLength) - 1})
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	contentType, _, err := sniffContentType(reader)
+	return contentType, err
+}
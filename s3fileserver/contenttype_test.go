@@ -0,0 +1,89 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSniffContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		want string
+	}{
+		{"empty", nil, "text/plain; charset=utf-8"},
+		{"html", []byte("<!DOCTYPE html><html><body>hi</body></html>"), "text/html; charset=utf-8"},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{"pdf", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"text", []byte("plain words"), "text/plain; charset=utf-8"},
+		{"binary", []byte{0, 1, 2, 3, 0xfe}, "application/octet-stream"},
+		{"longer than sniffed", append([]byte("<html>"), bytes.Repeat([]byte{0}, 2*sniffLength)...), "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, body, err := sniffContentType(bytes.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("type %q, want %q", got, tt.want)
+			}
+			// the sniffed bytes are put back in front of the body
+			if rest, err := io.ReadAll(body); err != nil || !bytes.Equal(rest, tt.body) {
+				t.Errorf("body changed by sniffing, %v", err)
+			}
+		})
+	}
+
+	if _, _, err := sniffContentType(errReader{io.ErrClosedPipe}); err != io.ErrClosedPipe {
+		t.Errorf("read error %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+// TestServeSniffedType serves objects through get and head, a stored type
+// is kept and only an untyped object read from its start is sniffed.
+func TestServeSniffedType(t *testing.T) {
+	html := []byte("<!DOCTYPE html><html><body>" + strings.Repeat("hi ", 200) + "</body></html>")
+
+	tests := []struct {
+		name   string
+		key    string
+		stored string
+		tags   map[string]string
+		rng    string
+		want   string
+	}{
+		{"untyped", "page", "application/octet-stream", nil, "", "text/html; charset=utf-8"},
+		{"binary octet stream", "page", "binary/octet-stream", nil, "", "text/html; charset=utf-8"},
+		{"no stored type", "page", "", nil, "", "text/html; charset=utf-8"},
+		{"stored type wins", "page", "text/plain", nil, "", "text/plain"},
+		{"extension wins", "page.txt", "application/octet-stream", nil, "", "text/plain; charset=utf-8"},
+		{"range past the start", "page", "application/octet-stream", nil, "bytes=10-20", "application/octet-stream"},
+		{"stored compressed", "page", "application/octet-stream", map[string]string{"content-encoding": "gzip"}, "", "application/octet-stream"},
+	}
+	for _, scheme := range []string{"xor", "ctr", "gcm", "cbc"} {
+		for _, tt := range tests {
+			t.Run(scheme+" "+tt.name, func(t *testing.T) {
+				store := newMemStore()
+				store.put(tt.key, encryptObject(t, scheme, html), tt.stored, tt.tags)
+				h := newTestServer(t, store, nil)
+				target := "/" + scheme + "/" + tt.key
+
+				if w := serve(h, http.MethodGet, target, nil, "Range", tt.rng); w.Header().Get("Content-Type") != tt.want {
+					t.Errorf("get: status %d, Content-Type %q, want %q", w.Code, w.Header().Get("Content-Type"), tt.want)
+				}
+				// head ignores the range, so it sniffs from the start
+				want := tt.want
+				if tt.rng != "" {
+					want = "text/html; charset=utf-8"
+				}
+				if w := serve(h, http.MethodHead, target, nil); w.Header().Get("Content-Type") != want {
+					t.Errorf("head: status %d, Content-Type %q, want %q", w.Code, w.Header().Get("Content-Type"), want)
+				}
+			})
+		}
+	}
+}
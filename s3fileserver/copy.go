@@ -0,0 +1,39 @@
+package s3fileserver
+
+import (
+	"io"
+	"sync"
+)
+
+// defaultCopyBufferSize is the size of the buffers response bodies are
+// copied through unless COPY_BUFFER_SIZE sets another.
+const defaultCopyBufferSize = 256 << 10
+
+// bufferPool hands out the buffers response bodies are copied through.
+type bufferPool struct {
+	pool *sync.Pool
+}
+
+func newBufferPool(size int) bufferPool {
+	return bufferPool{pool: &sync.Pool{
+		New: func() any {
+			buf := make([]byte, size)
+			return &buf
+		},
+	}}
+}
+
+// writerOnly hides any ReadFrom method of the wrapped writer, an
+// http.ResponseWriter would otherwise copy through its own 32 KiB buffer.
+type writerOnly struct {
+	io.Writer
+}
+
+// copy copies src to dst through a pooled buffer, the buffer goes back to
+// the pool however the copy ends.
+func (p bufferPool) copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := p.pool.Get().(*[]byte)
+	defer p.pool.Put(buf)
+
+	return io.CopyBuffer(writerOnly{dst}, src, *buf)
+}
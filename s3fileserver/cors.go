@@ -0,0 +1,79 @@
+package s3fileserver
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	corsAllowMethods  = "GET, HEAD, PUT, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, X-API-Key, Range, If-Match, If-None-Match, If-Modified-Since, If-Unmodified-Since, Content-Type"
+	corsExposeHeaders = "Accept-Ranges, Content-Disposition, Content-Length, Content-Range, ETag, Last-Modified, X-Request-ID"
+	corsMaxAge        = "600"
+)
+
+// corsPolicy adds the cors headers for allowed origins and answers
+// preflight requests.
+type corsPolicy struct {
+	origins  map[string]bool
+	wildcard bool
+}
+
+// newCORSPolicy parses a comma separated list of origins, "*" allows any
+// origin.
+func newCORSPolicy(allowedOrigins string) corsPolicy {
+	p := corsPolicy{origins: map[string]bool{}}
+	for _, origin := range strings.Split(allowedOrigins, ",") {
+		origin = strings.TrimSpace(origin)
+		switch origin {
+		case "":
+		case "*":
+			p.wildcard = true
+		default:
+			p.origins[strings.TrimSuffix(origin, "/")] = true
+		}
+	}
+
+	return p
+}
+
+func (p corsPolicy) middleware(next http.Handler) http.Handler {
+	if !p.wildcard && len(p.origins) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the allowed origin depends on the request when it is echoed
+		w.Header().Add("Vary", "Origin")
+		allowed := p.wildcard || p.origins[origin]
+		if allowed {
+			if p.wildcard {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		}
+
+		// preflight requests carry no credentials, answer them before auth
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package s3fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	do := func(p corsPolicy, method string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/xor/a.bin", nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		p.middleware(next).ServeHTTP(w, r)
+		return w
+	}
+
+	listed := newCORSPolicy("https://a.example, https://b.example/")
+
+	w := do(listed, http.MethodGet, "Origin", "https://b.example")
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://b.example" || w.Header().Get("Vary") != "Origin" || w.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Errorf("listed origin: headers %v", w.Header())
+	}
+
+	w = do(listed, http.MethodGet, "Origin", "https://evil.example")
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("other origin: status %d, headers %v", w.Code, w.Header())
+	}
+
+	w = do(listed, http.MethodGet)
+	if w.Header().Get("Vary") != "" {
+		t.Errorf("no origin: headers %v", w.Header())
+	}
+
+	// preflights are answered by the middleware
+	w = do(listed, http.MethodOptions, "Origin", "https://a.example", "Access-Control-Request-Method", "PUT")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != corsAllowMethods || w.Header().Get("Access-Control-Max-Age") != corsMaxAge {
+		t.Errorf("preflight: status %d, headers %v", w.Code, w.Header())
+	}
+	if w := do(listed, http.MethodOptions, "Origin", "https://evil.example", "Access-Control-Request-Method", "PUT"); w.Code != http.StatusForbidden {
+		t.Errorf("preflight of another origin: status %d, want 403", w.Code)
+	}
+
+	w = do(newCORSPolicy("*"), http.MethodGet, "Origin", "https://any.example")
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("wildcard: headers %v", w.Header())
+	}
+
+	w = do(newCORSPolicy(""), http.MethodGet, "Origin", "https://a.example")
+	if len(w.Header()) != 0 {
+		t.Errorf("no origins configured: headers %v", w.Header())
+	}
+}
@@ -0,0 +1,40 @@
+package s3fileserver
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aws/smithy-go"
+)
+
+// DeleteFile deletes an object. RegisterHandlers only mounts it when
+// ALLOW_DELETE is set, so read-only deployments cannot lose objects.
+func (h HTTPFileServer) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/file/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	// s3 reports success for missing keys, check the object exists first
+	if _, err := h.s3Client.HeadObject(r.Context(), bucket, objKey, ""); err != nil {
+		h.writeS3Error(w, err)
+		return
+	}
+
+	if err := h.s3Client.DeleteObject(r.Context(), bucket, objKey); err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDenied" {
+			http.Error(w, "s3 denied deleting the object, check the bucket policy", http.StatusForbidden)
+			return
+		}
+
+		h.writeS3Error(w, err)
+		return
+	}
+	h.headCache.Remove(objectCacheKey(bucket, objKey))
+	h.ivCache.Remove(objectCacheKey(bucket, objKey))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,77 @@
+package s3fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// setContentDisposition marks the response as a download when a filename is
+// given by the download query parameter or the filename object tag. Without
+// a name the header is left out so browsers still display inline.
+func setContentDisposition(w http.ResponseWriter, r *http.Request, tagMap map[string]string) {
+	name := r.URL.Query().Get("download")
+	if name == "" {
+		name = tagMap["filename"]
+	}
+
+	// control characters have no place in a header value
+	name = strings.Map(func(c rune) rune {
+		if unicode.IsControl(c) {
+			return -1
+		}
+		return c
+	}, name)
+	if name == "" {
+		return
+	}
+
+	w.Header().Set("Content-Disposition", contentDisposition(name))
+}
+
+// contentDisposition formats an attachment header value. Names that are not
+// plain ascii get an ascii fallback in filename and the exact name in the
+// RFC 5987 encoded filename* parameter.
+func contentDisposition(name string) string {
+	fallback := strings.Map(func(c rune) rune {
+		if c > unicode.MaxASCII || c == '"' || c == '\\' {
+			return '_'
+		}
+		return c
+	}, name)
+
+	value := fmt.Sprintf("attachment; filename=\"%s\"", fallback)
+	if fallback != name {
+		value += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+
+	return value
+}
+
+// encodeRFC5987 percent encodes every byte outside the attr-char set.
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+
+	return b.String()
+}
+
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,32 @@
+package s3fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		query string
+		tags  map[string]string
+		want  string
+	}{
+		{want: ""},
+		{query: "?download=report.pdf", want: `attachment; filename="report.pdf"`},
+		{tags: map[string]string{"filename": "tagged.pdf"}, want: `attachment; filename="tagged.pdf"`},
+		{query: "?download=query.pdf", tags: map[string]string{"filename": "tagged.pdf"}, want: `attachment; filename="query.pdf"`},
+		{query: "?download=a%22b%5C.txt", want: `attachment; filename="a_b_.txt"; filename*=UTF-8''a%22b%5C.txt`},
+		{query: "?download=r%C3%A9sum%C3%A9.pdf", want: `attachment; filename="r_sum_.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`},
+		{query: "?download=a%0D%0Ab.txt", want: `attachment; filename="ab.txt"`},
+		{query: "?download=%0A", want: ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		setContentDisposition(w, httptest.NewRequest(http.MethodGet, "/xor/a.bin"+tt.query, nil), tt.tags)
+		if got := w.Header().Get("Content-Disposition"); got != tt.want {
+			t.Errorf("%q %v: got %q, want %q", tt.query, tt.tags, got, tt.want)
+		}
+	}
+}
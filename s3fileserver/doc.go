@@ -0,0 +1,17 @@
+// Package s3fileserver serves objects of s3 buckets over http, decrypting
+// them on the fly with xor, aes-ctr, aes-gcm or chacha20. It can be embedded
+// by registering the handlers of an HTTPFileServer on another mux:
+//
+//	store, err := s3fileserver.NewS3Client(accessKey, secret, region, false, "", false, 0)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	fileServer, err := s3fileserver.NewHTTPFileServer(store, "bucket", xorKey, aesKey)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	fileServer.RegisterHandlers(mux, s3fileserver.WithPrefix("xor", "/media/"))
+//
+// Run is the configuration from the environment used by the s3-file-server
+// command.
+package s3fileserver
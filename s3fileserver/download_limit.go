@@ -0,0 +1,43 @@
+package s3fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// downloadLimit caps the number of bytes a single download may return. The
+// limit of an endpoint, the first segment of the request path, overrides the
+// default one, and zero means no limit.
+type downloadLimit struct {
+	defaultMax   int64
+	endpoints    map[string]int64
+	exemptSigned bool
+}
+
+// newDownloadLimit takes the default limit, the limits of single endpoints
+// and whether requests with a valid url signature skip the limit.
+func newDownloadLimit(defaultMax int64, endpoints map[string]int64, exemptSigned bool) downloadLimit {
+	return downloadLimit{defaultMax: defaultMax, endpoints: endpoints, exemptSigned: exemptSigned}
+}
+
+// allow reports whether a download of size bytes may be served. Otherwise a
+// 413 telling the client to request smaller ranges has been written.
+func (l downloadLimit) allow(w http.ResponseWriter, r *http.Request, size int64) bool {
+	if l.exemptSigned && signedRequest(r.Context()) {
+		return true
+	}
+
+	endpoint, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	limit, ok := l.endpoints[endpoint]
+	if !ok {
+		limit = l.defaultMax
+	}
+	if limit <= 0 || size <= limit {
+		return true
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	http.Error(w, fmt.Sprintf("download of %d bytes exceeds the limit of %d bytes, request it in ranges", size, limit), http.StatusRequestEntityTooLarge)
+	return false
+}
@@ -0,0 +1,66 @@
+package s3fileserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestDownloadLimit refuses downloads over the limit of their endpoint,
+// counting the bytes of every requested range.
+func TestDownloadLimit(t *testing.T) {
+	store := newMemStore()
+	store.put("a.bin", encryptObject(t, "xor", testPlaintext(1000)), "application/octet-stream", nil)
+	h := newTestServer(t, store, func(opts *serverOptions) {
+		opts.downloadLimit = newDownloadLimit(500, map[string]int64{"raw": 0, "ctr": 100}, true)
+	})
+
+	tests := []struct {
+		target string
+		rng    string
+		want   int
+	}{
+		{target: "/xor/a.bin", want: http.StatusRequestEntityTooLarge},
+		{target: "/xor/a.bin", rng: "bytes=0-499", want: http.StatusPartialContent},
+		{target: "/xor/a.bin", rng: "bytes=0-299,500-799", want: http.StatusRequestEntityTooLarge},
+		{target: "/xor/a.bin", rng: "bytes=0-199,500-699", want: http.StatusPartialContent},
+		{target: "/raw/a.bin", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		var header []string
+		if tt.rng != "" {
+			header = []string{"Range", tt.rng}
+		}
+		gets := store.getCount()
+		w := serve(h, http.MethodGet, tt.target, nil, header...)
+		if w.Code != tt.want {
+			t.Errorf("%s %s: status %d, want %d", tt.target, tt.rng, w.Code, tt.want)
+		}
+		if w.Code == http.StatusRequestEntityTooLarge && store.getCount() != gets {
+			t.Errorf("%s %s: refused download read the object", tt.target, tt.rng)
+		}
+	}
+
+	// a head request reports the size without a body
+	if w := serve(h, http.MethodHead, "/xor/a.bin", nil); w.Code != http.StatusOK {
+		t.Errorf("head: status %d", w.Code)
+	}
+}
+
+func TestDownloadLimitExemptSigned(t *testing.T) {
+	limit := newDownloadLimit(10, nil, true)
+	r := httptest.NewRequest(http.MethodGet, "/xor/a.bin", nil)
+	if limit.allow(httptest.NewRecorder(), r, 11) {
+		t.Error("unsigned download over the limit allowed")
+	}
+	signed := r.WithContext(context.WithValue(r.Context(), signedRequestKey{}, true))
+	if !limit.allow(httptest.NewRecorder(), signed, 11) {
+		t.Error("signed download refused")
+	}
+
+	limit.exemptSigned = false
+	if limit.allow(httptest.NewRecorder(), signed, 11) {
+		t.Error("signed download allowed without the exemption")
+	}
+}
@@ -0,0 +1,117 @@
+package s3fileserver
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// storedEncoding prepares the response of an object stored gzip compressed
+// before encryption, as named by its content-encoding tag. Clients accepting
+// gzip get the decrypted bytes as they are with Content-Encoding: gzip, and
+// ranges of the compressed bytes. Other clients get the object decompressed,
+// which cannot serve plaintext ranges, so a range request gets a 416. done
+// must be called once the response is written, ok is false when the response
+// has already been written.
+func (h HTTPFileServer) storedEncoding(w http.ResponseWriter, r *http.Request, tagMap map[string]string) (rw http.ResponseWriter, done func(), ok bool) {
+	if !strings.EqualFold(tagMap["content-encoding"], "gzip") {
+		return w, func() {}, true
+	}
+
+	// a missing Accept-Encoding header accepts any coding
+	header := r.Header.Get("Accept-Encoding")
+	accepts := header == "" || negotiateEncoding(header, []string{"gzip"}) == "gzip"
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if !accepts && r.Header.Get("Range") != "" && r.Method != http.MethodHead {
+		w.Header().Set("Accept-Ranges", "none")
+		http.Error(w, "ranges of a compressed object need Accept-Encoding: gzip", http.StatusRequestedRangeNotSatisfiable)
+		return nil, nil, false
+	}
+
+	sw := &storedGzipWriter{ResponseWriter: w, buffers: h.buffers, decompress: !accepts}
+	return sw, func() {
+		if err := sw.Close(); err != nil {
+			slog.ErrorContext(r.Context(), "failed to decompress file", "path", r.URL.Path, "err", err)
+		}
+	}, true
+}
+
+// storedGzipWriter labels or decompresses the body of a successful response,
+// error responses are passed through untouched.
+type storedGzipWriter struct {
+	http.ResponseWriter
+	buffers     bufferPool
+	decompress  bool
+	decoding    bool
+	wroteHeader bool
+	pw          *io.PipeWriter
+	copied      chan error
+}
+
+func (w *storedGzipWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.Header()
+	if status == http.StatusOK || status == http.StatusPartialContent {
+		if !w.decompress {
+			header.Set("Content-Encoding", "gzip")
+		} else {
+			header.Del("Content-Length")
+			header.Set("Accept-Ranges", "none")
+
+			// the decompressed bytes are a different representation
+			if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				header.Set("ETag", "W/"+etag)
+			}
+			w.decoding = true
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *storedGzipWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decoding {
+		return w.ResponseWriter.Write(p)
+	}
+
+	// the gzip reader pulls the written bytes through a pipe, started with
+	// the first write since a head response has no body to decode
+	if w.pw == nil {
+		pr, pw := io.Pipe()
+		w.pw = pw
+		w.copied = make(chan error, 1)
+		go func() {
+			zr, err := gzip.NewReader(pr)
+			if err == nil {
+				_, err = w.buffers.copy(w.ResponseWriter, zr)
+			}
+			pr.CloseWithError(err)
+			w.copied <- err
+		}()
+	}
+	return w.pw.Write(p)
+}
+
+// Close waits for the decompressed body to be written.
+func (w *storedGzipWriter) Close() error {
+	if w.pw == nil {
+		return nil
+	}
+
+	w.pw.Close()
+	return <-w.copied
+}
+
+func (w *storedGzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
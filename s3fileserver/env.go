@@ -0,0 +1,131 @@
+package s3fileserver
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envReader reads typed settings from the environment. Invalid values are
+// collected as problems and read as their default, so every problem of a
+// configuration can be reported at once.
+type envReader struct {
+	problems ConfigError
+}
+
+func (e *envReader) problem(format string, args ...any) {
+	e.problems = append(e.problems, fmt.Sprintf(format, args...))
+}
+
+func (e *envReader) string(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// bool reads a flag, which is only on when set to 1.
+func (e *envReader) bool(name string) bool {
+	return os.Getenv(name) == "1"
+}
+
+func (e *envReader) int(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.problem("invalid %s, err: %v", name, err)
+		return def
+	}
+
+	return n
+}
+
+func (e *envReader) int64(name string, def int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		e.problem("invalid %s, err: %v", name, err)
+		return def
+	}
+
+	return n
+}
+
+func (e *envReader) float(name string, def float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.problem("invalid %s, err: %v", name, err)
+		return def
+	}
+
+	return f
+}
+
+func (e *envReader) duration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		e.problem("invalid %s, err: %v", name, err)
+		return def
+	}
+
+	return d
+}
+
+// key reads a key decoded with the encoding named by name_ENCODING, see
+// decodeKey.
+func (e *envReader) key(name string) []byte {
+	key, err := decodeKey(os.Getenv(name), os.Getenv(name+"_ENCODING"))
+	if err != nil {
+		e.problem("failed to decode %s, err: %v", name, err)
+		return nil
+	}
+	return key
+}
+
+// list reads a comma separated list, dropping empty items.
+func (e *envReader) list(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// decodeKey decodes a key read from the environment. encoding is one of
+// "hex", "base64" or empty for the raw value.
+func decodeKey(value string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", "raw":
+		return []byte(value), nil
+	case "hex":
+		return hex.DecodeString(value)
+	case "base64":
+		return base64.StdEncoding.DecodeString(value)
+	default:
+		return nil, fmt.Errorf("unknown key encoding %q, must be hex, base64 or raw", encoding)
+	}
+}
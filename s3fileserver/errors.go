@@ -0,0 +1,90 @@
+package s3fileserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// mapS3Error returns the http status a failed s3 call is answered with.
+func mapS3Error(err error) int {
+	var notFoundErr *types.NotFound
+	var noSuchKeyErr *types.NoSuchKey
+	if errors.As(err, &notFoundErr) || errors.As(err, &noSuchKeyErr) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+
+	// the remaining errors are only known by their code
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey", "NoSuchVersion", "NotFound":
+			return http.StatusNotFound
+		// a denied head request has no body, s3 only reports Forbidden
+		case "AccessDenied", "Forbidden":
+			return http.StatusForbidden
+		case "InvalidRange":
+			return http.StatusRequestedRangeNotSatisfiable
+		case "PreconditionFailed":
+			return http.StatusPreconditionFailed
+		case "SlowDown", "ServiceUnavailable":
+			return http.StatusServiceUnavailable
+		}
+	}
+
+	return http.StatusInternalServerError
+}
+
+// s3RequestIDs returns the request id and extended request id (x-amz-id-2)
+// s3 assigned to the failed call, empty when err carries none.
+func s3RequestIDs(err error) (reqID, extID string) {
+	var reqErr interface{ ServiceRequestID() string }
+	if errors.As(err, &reqErr) {
+		reqID = reqErr.ServiceRequestID()
+	}
+	var hostErr interface{ ServiceHostID() string }
+	if errors.As(err, &hostErr) {
+		extID = hostErr.ServiceHostID()
+	}
+	return reqID, extID
+}
+
+// writeS3Error responds to a failed s3 call with the status from mapS3Error.
+// With exposeS3RequestIDs set, 5xx responses carry the s3 request ids of the
+// call, so clients can quote them in support tickets.
+func (h HTTPFileServer) writeS3Error(w http.ResponseWriter, err error) {
+	status := mapS3Error(err)
+	if h.exposeS3RequestIDs && status >= http.StatusInternalServerError {
+		reqID, extID := s3RequestIDs(err)
+		if reqID != "" {
+			w.Header().Set("X-Amz-Request-Id", reqID)
+		}
+		if extID != "" {
+			w.Header().Set("X-Amz-Id-2", extID)
+		}
+	}
+
+	switch status {
+	case http.StatusNotFound:
+		w.WriteHeader(status)
+	case http.StatusGatewayTimeout:
+		http.Error(w, "s3 request timed out", status)
+	case http.StatusForbidden:
+		http.Error(w, "s3 denied access to the object", status)
+	case http.StatusRequestedRangeNotSatisfiable:
+		http.Error(w, "s3 rejected the requested range", status)
+	case http.StatusPreconditionFailed:
+		http.Error(w, "object changed while it was read, retry the request", status)
+	case http.StatusServiceUnavailable:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "s3 is throttling requests", status)
+	default:
+		http.Error(w, err.Error(), status)
+	}
+}
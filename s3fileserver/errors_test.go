@@ -0,0 +1,76 @@
+package s3fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestMapS3Error(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{err: &types.NotFound{}, want: http.StatusNotFound},
+		{err: fmt.Errorf("get: %w", &types.NoSuchKey{}), want: http.StatusNotFound},
+		{err: &smithy.GenericAPIError{Code: "NoSuchVersion"}, want: http.StatusNotFound},
+		{err: &smithy.GenericAPIError{Code: "AccessDenied"}, want: http.StatusForbidden},
+		{err: &smithy.GenericAPIError{Code: "Forbidden"}, want: http.StatusForbidden},
+		{err: &smithy.GenericAPIError{Code: "InvalidRange"}, want: http.StatusRequestedRangeNotSatisfiable},
+		{err: &smithy.GenericAPIError{Code: "PreconditionFailed"}, want: http.StatusPreconditionFailed},
+		{err: &smithy.GenericAPIError{Code: "SlowDown"}, want: http.StatusServiceUnavailable},
+		{err: &smithy.GenericAPIError{Code: "InternalError"}, want: http.StatusInternalServerError},
+		{err: fmt.Errorf("head: %w", context.DeadlineExceeded), want: http.StatusGatewayTimeout},
+		{err: errors.New("connection refused"), want: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := mapS3Error(tt.err); got != tt.want {
+			t.Errorf("%v: got %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// requestIDError carries s3 request ids like the sdk response errors do.
+type requestIDError struct {
+	error
+}
+
+func (e requestIDError) Unwrap() error          { return e.error }
+func (requestIDError) ServiceRequestID() string { return "REQ123" }
+func (requestIDError) ServiceHostID() string    { return "HOST456" }
+
+func TestWriteS3ErrorRequestIDs(t *testing.T) {
+	failed := fmt.Errorf("get: %w", requestIDError{&smithy.GenericAPIError{Code: "InternalError"}})
+	missing := requestIDError{&types.NoSuchKey{}}
+
+	for _, expose := range []bool{false, true} {
+		h := newTestServer(t, newMemStore(), func(opts *serverOptions) { opts.exposeS3RequestIDs = expose })
+
+		w := httptest.NewRecorder()
+		h.writeS3Error(w, failed)
+		got := w.Header().Get("X-Amz-Request-Id") == "REQ123" && w.Header().Get("X-Amz-Id-2") == "HOST456"
+		if w.Code != http.StatusInternalServerError || got != expose {
+			t.Errorf("expose %v: status %d, headers %v", expose, w.Code, w.Header())
+		}
+
+		// only server errors carry the ids
+		w = httptest.NewRecorder()
+		h.writeS3Error(w, missing)
+		if w.Code != http.StatusNotFound || w.Header().Get("X-Amz-Request-Id") != "" {
+			t.Errorf("expose %v: missing object: status %d, headers %v", expose, w.Code, w.Header())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	newTestServer(t, newMemStore(), nil).writeS3Error(w, &smithy.GenericAPIError{Code: "SlowDown"})
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("throttled: status %d, headers %v", w.Code, w.Header())
+	}
+}
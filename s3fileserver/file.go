@@ -0,0 +1,96 @@
+package s3fileserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ServeFile serves an object whose encryption scheme and key are read from
+// its encryption and key-id tags, so the url does not depend on how the
+// object was encrypted. Objects without an encryption tag use the default
+// scheme.
+func (h HTTPFileServer) ServeFile(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/file/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	meta, tagMap, ok := h.objectInfo(w, r, bucket, objKey)
+	if !ok {
+		return
+	}
+
+	h.serveTagged(w, r, objKey, meta, tagMap)
+}
+
+// serveTagged serves an object decrypted by the scheme and key of its tags.
+func (h HTTPFileServer) serveTagged(w http.ResponseWriter, r *http.Request, objKey string, meta objectMeta, tagMap map[string]string) {
+	encryption := h.objectEncryption(tagMap)
+	keyID := tagMap["key-id"]
+
+	switch encryption {
+	case "none":
+		h.serveStream(w, r, objKey, meta, tagMap, "none", plainDecrypter)
+	case "xor":
+		key, err := h.xorKeys.get(keyID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.serveStream(w, r, objKey, meta, tagMap, "xor", xorDecrypter(key))
+	case "ctr", "gcm", "cbc":
+		block, err := h.aesKeys.get(keyID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		switch encryption {
+		case "ctr":
+			h.serveCTR(w, r, objKey, meta, tagMap, block)
+		case "gcm":
+			h.serveGCM(w, r, objKey, meta, tagMap, block)
+		default:
+			h.serveCBC(w, r, objKey, meta, tagMap, block)
+		}
+	case "chacha":
+		key, err := h.chachaKeys.get(keyID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.serveChaCha(w, r, objKey, meta, tagMap, key)
+	default:
+		http.Error(w, fmt.Sprintf("unknown encryption %q", encryption), http.StatusInternalServerError)
+	}
+}
+
+// objectEncryption returns the encryption scheme of an object from its
+// encryption tag, falling back to the default scheme.
+func (h HTTPFileServer) objectEncryption(tagMap map[string]string) string {
+	if encryption := tagMap["encryption"]; encryption != "" {
+		return encryption
+	}
+	return h.defaultEncryption
+}
+
+// validEncryption reports whether ServeFile knows the encryption scheme.
+func validEncryption(encryption string) bool {
+	switch encryption {
+	case "none", "xor", "ctr", "gcm", "chacha", "cbc":
+		return true
+	}
+	return false
+}
+
+func plainDecrypter(reader io.Reader, offset int64) io.Reader {
+	return reader
+}
+
+func xorDecrypter(key []byte) func(io.Reader, int64) io.Reader {
+	return func(reader io.Reader, offset int64) io.Reader {
+		return NewXorReader(reader, key, offset)
+	}
+}
@@ -0,0 +1,384 @@
+package s3fileserver
+
+import (
+	"context"
+	"crypto/cipher"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPFileServer serves and stores objects of an ObjectStore, decrypting and
+// encrypting them on the way. Its methods are http handlers expecting the
+// object key after the endpoint prefix, e.g. /xor/<key>.
+type HTTPFileServer struct {
+	s3Client           ObjectStore
+	buckets            bucketRouter
+	xorKeys            keyring[[]byte]
+	aesKeys            keyring[cipher.Block]
+	chachaKeys         keyring[[]byte]
+	defaultEncryption  string
+	headCache          *ttlCache[string, objectMeta]
+	missCache          *ttlCache[string, error]
+	ivCache            *ttlCache[string, cachedIV]
+	contentCache       *contentCache
+	buffers            bufferPool
+	exposeS3RequestIDs bool
+	hmacKey            []byte
+	hmacStrictMaxSize  int64
+	parallelParts      int
+	parallelPartSize   int64
+	ctrInlineMax       int64
+	readRetries        int
+	listMaxKeys        int
+	listPrefixes       []string
+	allowDelete        bool
+	presignExpiry      time.Duration
+	cacheControl       string
+	keyPrefix          string
+	aliases            keyAliases
+	contentTypes       contentTypeResolver
+	downloadLimit      downloadLimit
+	maxUploadSize      int64
+	thumbnails         thumbnailLimits
+}
+
+// NewHTTPFileServer returns a file server for the objects of bucket. The xor
+// key is used by the /xor/ endpoint and the aes key by the /ctr/ and /gcm/
+// endpoints, either may be nil when its endpoints are not served. Caching,
+// integrity checks and download limits are left off.
+func NewHTTPFileServer(s3Client ObjectStore, bucket string, xorKey []byte, aesKey []byte) (HTTPFileServer, error) {
+	opts := defaultServerOptions()
+
+	buckets, err := newBucketRouter(bucket, "", "")
+	if err != nil {
+		return HTTPFileServer{}, err
+	}
+	opts.buckets = buckets
+
+	xorKeys := map[string][]byte{}
+	if len(xorKey) != 0 {
+		xorKeys[defaultKeyID] = xorKey
+	}
+	opts.xorKeys = newKeyring("xor", defaultKeyID, xorKeys)
+
+	aesKeys := map[string]cipher.Block{}
+	if len(aesKey) != 0 {
+		block, err := NewAESCipher(aesKey)
+		if err != nil {
+			return HTTPFileServer{}, err
+		}
+		aesKeys[defaultKeyID] = block
+	}
+	opts.aesKeys = newKeyring("aes", defaultKeyID, aesKeys)
+
+	return newHTTPFileServer(s3Client, opts), nil
+}
+
+// serverOptions configures newHTTPFileServer. Run takes them from a Config,
+// see Config.serverOptions, and NewHTTPFileServer from defaultServerOptions.
+type serverOptions struct {
+	buckets           bucketRouter
+	xorKeys           keyring[[]byte]
+	aesKeys           keyring[cipher.Block]
+	chachaKeys        keyring[[]byte]
+	defaultEncryption string
+
+	// caches, a zero size or ttl disables one
+	headCacheTTL              time.Duration
+	headCacheSize             int
+	negativeCacheTTL          time.Duration
+	negativeCacheSize         int
+	ivCacheSize               int
+	contentCacheBytes         int64
+	contentCacheMaxObjectSize int64
+
+	// downloads
+	copyBufferSize     int
+	exposeS3RequestIDs bool
+	hmacKey            []byte
+	hmacStrictMaxSize  int64
+	parallelParts      int
+	parallelPartSize   int64
+	ctrInlineMax       int64
+	readRetries        int
+	cacheControl       string
+	contentTypes       contentTypeResolver
+	downloadLimit      downloadLimit
+	thumbnails         thumbnailLimits
+
+	// keys, listing, uploads and deletes
+	keyPrefix     string
+	aliases       keyAliases
+	listMaxKeys   int
+	listPrefixes  []string
+	allowDelete   bool
+	presignExpiry time.Duration
+	maxUploadSize int64
+}
+
+// defaultServerOptions serves a single bucket without keys, with every
+// optional feature off.
+func defaultServerOptions() serverOptions {
+	return serverOptions{
+		xorKeys:           newKeyring("xor", defaultKeyID, map[string][]byte{}),
+		aesKeys:           newKeyring("aes", defaultKeyID, map[string]cipher.Block{}),
+		chachaKeys:        newKeyring("chacha", defaultKeyID, map[string][]byte{}),
+		defaultEncryption: "none",
+		copyBufferSize:    defaultCopyBufferSize,
+		thumbnails:        newThumbnailLimits(defaultThumbnailMaxSize, defaultThumbnailMaxPixels),
+		listMaxKeys:       1000,
+		presignExpiry:     15 * time.Minute,
+		maxUploadSize:     1 << 30,
+	}
+}
+
+func newHTTPFileServer(s3Client ObjectStore, opts serverOptions) HTTPFileServer {
+	return HTTPFileServer{
+		s3Client:           s3Client,
+		buckets:            opts.buckets,
+		xorKeys:            opts.xorKeys,
+		aesKeys:            opts.aesKeys,
+		chachaKeys:         opts.chachaKeys,
+		defaultEncryption:  opts.defaultEncryption,
+		headCache:          newTTLCache[string, objectMeta](opts.headCacheTTL, opts.headCacheSize),
+		missCache:          newTTLCache[string, error](opts.negativeCacheTTL, opts.negativeCacheSize),
+		ivCache:            newTTLCache[string, cachedIV](ivCacheTTL, opts.ivCacheSize),
+		contentCache:       newContentCache(opts.contentCacheBytes, opts.contentCacheMaxObjectSize),
+		buffers:            newBufferPool(opts.copyBufferSize),
+		exposeS3RequestIDs: opts.exposeS3RequestIDs,
+		hmacKey:            opts.hmacKey,
+		hmacStrictMaxSize:  opts.hmacStrictMaxSize,
+		parallelParts:      opts.parallelParts,
+		parallelPartSize:   opts.parallelPartSize,
+		ctrInlineMax:       opts.ctrInlineMax,
+		readRetries:        opts.readRetries,
+		listMaxKeys:        opts.listMaxKeys,
+		listPrefixes:       opts.listPrefixes,
+		allowDelete:        opts.allowDelete,
+		presignExpiry:      opts.presignExpiry,
+		cacheControl:       opts.cacheControl,
+		keyPrefix:          normalizeKeyPrefix(opts.keyPrefix),
+		aliases:            opts.aliases,
+		contentTypes:       opts.contentTypes,
+		downloadLimit:      opts.downloadLimit,
+		maxUploadSize:      opts.maxUploadSize,
+		thumbnails:         opts.thumbnails,
+	}
+}
+
+// ServeXORFile serves an object xor encrypted with the xor key.
+func (h HTTPFileServer) ServeXORFile(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/xor/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	meta, tagMap, ok := h.objectInfo(w, r, bucket, objKey)
+	if !ok {
+		return
+	}
+
+	// objects encrypted with an older key name it in the key-id tag
+	key, err := h.xorKeys.get(tagMap["key-id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.serveStream(w, r, objKey, meta, tagMap, "xor", xorDecrypter(key))
+}
+
+// serveStream serves an object whose bytes can be decrypted from any offset
+// on their own, decrypt wraps the s3 body starting at offset.
+func (h HTTPFileServer) serveStream(w http.ResponseWriter, r *http.Request, objKey string, meta objectMeta, tagMap map[string]string, scheme string, decrypt func(reader io.Reader, offset int64) io.Reader) {
+	h.serveObject(w, r, objKey, meta, tagMap, objectFormat{
+		scheme:        scheme,
+		plaintextSize: plainSize,
+		openPart: func(rng byteRange) (io.ReadCloser, error) {
+			getObj, err := h.getRange(r.Context(), objKey, meta, rng.start, rng.end)
+			if err != nil {
+				return nil, err
+			}
+			return readCloser{decrypt(getObj.Body, rng.start), getObj.Body}, nil
+		},
+	})
+}
+
+// ServeCTRFile serves an object aes-ctr encrypted behind a 16 byte iv.
+func (h HTTPFileServer) ServeCTRFile(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/ctr/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	meta, tagMap, ok := h.objectInfo(w, r, bucket, objKey)
+	if !ok {
+		return
+	}
+
+	block, err := h.aesKeys.get(tagMap["key-id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.serveCTR(w, r, objKey, meta, tagMap, block)
+}
+
+func (h HTTPFileServer) serveCTR(w http.ResponseWriter, r *http.Request, objKey string, meta objectMeta, tagMap map[string]string, block cipher.Block) {
+	if err := checkCTRBlock(block); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	newReader := func(body io.Reader, iv []byte, offset int64) (io.Reader, error) {
+		return NewCTRReader(body, block, iv, offset)
+	}
+
+	// the iv is one block of the cipher, 16 bytes for aes. small objects
+	// are fetched whole, saving the separate iv request
+	ivSize := block.BlockSize()
+	openPart := h.ivParts(r.Context(), objKey, meta, ivSize, newReader)
+	if meta.contentLength <= h.ctrInlineMax {
+		openPart = h.inlineParts(r.Context(), objKey, meta, ivSize, newReader)
+	}
+
+	h.serveObject(w, r, objKey, meta, tagMap, objectFormat{
+		scheme:        "ctr",
+		plaintextSize: storedSize("ctr", int64(ivSize), "iv"),
+		openPart:      openPart,
+	})
+}
+
+// ServeChaChaFile serves an object chacha20 encrypted behind a 12 byte nonce.
+func (h HTTPFileServer) ServeChaChaFile(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/chacha/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	meta, tagMap, ok := h.objectInfo(w, r, bucket, objKey)
+	if !ok {
+		return
+	}
+
+	key, err := h.chachaKeys.get(tagMap["key-id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.serveChaCha(w, r, objKey, meta, tagMap, key)
+}
+
+func (h HTTPFileServer) serveChaCha(w http.ResponseWriter, r *http.Request, objKey string, meta objectMeta, tagMap map[string]string, key []byte) {
+	nonceSize := storedSize("chacha", chachaNonceSize, "nonce")
+	h.serveObject(w, r, objKey, meta, tagMap, objectFormat{
+		scheme: "chacha",
+		// more than the keystream covers was not written by NewChaChaWriter
+		plaintextSize: func(fileSize int64) (int64, error) {
+			size, err := nonceSize(fileSize)
+			if err == nil && size > chachaMaxSize {
+				return 0, corruptObjectError("corrupt chacha object, " + errChaChaTooLarge.Error())
+			}
+			return size, err
+		},
+		openPart: h.ivParts(r.Context(), objKey, meta, chachaNonceSize, func(body io.Reader, nonce []byte, offset int64) (io.Reader, error) {
+			return NewChaChaReader(body, key, nonce, offset)
+		}),
+	})
+}
+
+// ServeGCMFile serves an object aes-gcm encrypted in authenticated chunks.
+func (h HTTPFileServer) ServeGCMFile(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/gcm/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	meta, tagMap, ok := h.objectInfo(w, r, bucket, objKey)
+	if !ok {
+		return
+	}
+
+	block, err := h.aesKeys.get(tagMap["key-id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.serveGCM(w, r, objKey, meta, tagMap, block)
+}
+
+func (h HTTPFileServer) serveGCM(w http.ResponseWriter, r *http.Request, objKey string, meta objectMeta, tagMap map[string]string, block cipher.Block) {
+	fileSize := meta.contentLength
+	realFileSize, sizeErr := gcmPlaintextSize(fileSize)
+	prefix := h.sharedIV(r.Context(), objKey, meta, gcmNoncePrefixSize)
+
+	h.serveObject(w, r, objKey, meta, tagMap, objectFormat{
+		scheme: "gcm",
+		plaintextSize: func(int64) (int64, error) {
+			if sizeErr != nil {
+				return 0, corruptObjectError(sizeErr.Error())
+			}
+			return realFileSize, nil
+		},
+		// each range reads the whole chunks holding it
+		openPart: func(rng byteRange) (io.ReadCloser, error) {
+			noncePrefix, err := prefix()
+			if err != nil {
+				return nil, err
+			}
+
+			storageStart, storageEnd := gcmStorageRange(rng.start, rng.end, fileSize)
+			getObj, err := h.getRange(r.Context(), objKey, meta, storageStart, storageEnd)
+			if err != nil {
+				return nil, err
+			}
+			gcmReader, err := NewGCMReader(getObj.Body, block, noncePrefix, rng.start, realFileSize)
+			if err != nil {
+				getObj.Body.Close()
+				return nil, err
+			}
+			return readCloser{io.LimitReader(gcmReader, rng.length()), getObj.Body}, nil
+		},
+	})
+}
+
+// closeOnCancel closes body as soon as ctx is done, so an aborted download
+// releases its s3 connection right away instead of once the copy fails. The
+// returned func stops watching ctx.
+func closeOnCancel(ctx context.Context, body io.Closer) func() bool {
+	return context.AfterFunc(ctx, func() {
+		body.Close()
+	})
+}
+
+// writeEmptyFile answers a request for a file without content. An empty file
+// has no satisfiable range, so none are advertised and a range request gets
+// a 416.
+func writeEmptyFile(w http.ResponseWriter, r *http.Request, meta objectMeta) {
+	w.Header().Set("Accept-Ranges", "none")
+	if r.Header.Get("Range") != "" && r.Method != http.MethodHead {
+		writeRangeNotSatisfiable(w, 0)
+		return
+	}
+
+	w.Header().Set("Content-Type", meta.contentType)
+	w.Header().Set("Content-Length", "0")
+	setLastModified(w, meta.lastModified)
+	w.WriteHeader(http.StatusOK)
+}
+
+// setLastModified sets the Last-Modified header unless the time is unknown.
+func setLastModified(w http.ResponseWriter, lastModified time.Time) {
+	if lastModified.IsZero() {
+		return
+	}
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+}
@@ -0,0 +1,180 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TestObjectStoreFake serves through the exported constructor and handlers
+// with an ObjectStore that is not s3.
+func TestObjectStoreFake(t *testing.T) {
+	store := newMemStore()
+	plain := testPlaintext(1000)
+	store.put("a.bin", encryptObject(t, "xor", plain), "application/octet-stream", nil)
+	store.put("b.bin", encryptObject(t, "ctr", plain), "application/octet-stream", nil)
+
+	h, err := NewHTTPFileServer(store, "bucket", []byte("secretkey"), []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handlers := map[string]http.HandlerFunc{
+		"/xor/a.bin": h.ServeXORFile,
+		"/ctr/b.bin": h.ServeCTRFile,
+	}
+	for target, handler := range handlers {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+			t.Errorf("%s: status %d, body matches %v", target, w.Code, bytes.Equal(w.Body.Bytes(), plain))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeXORFile(w, httptest.NewRequest(http.MethodGet, "/xor/missing.bin", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing object: status %d, want 404", w.Code)
+	}
+}
+
+// TestServeKeyID decrypts objects whose key-id tag names a key other than
+// the default, through their scheme's endpoint and through /file/.
+func TestServeKeyID(t *testing.T) {
+	plain := testPlaintext(1000)
+	otherAES, err := NewAESCipher([]byte("fedcba9876543210fedcba9876543210"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMemStore()
+	h := newTestServer(t, store, func(opts *serverOptions) {
+		opts.xorKeys = newKeyring("xor", "new", map[string][]byte{"new": []byte("otherkey"), "old": []byte("secretkey")})
+		opts.aesKeys = newKeyring("aes", "new", map[string]cipher.Block{"new": otherAES, "old": testAESBlock(t)})
+		opts.chachaKeys = newKeyring("chacha", "new", map[string][]byte{"new": []byte("fedcba9876543210fedcba9876543210"), "old": testChaChaKey})
+	})
+
+	for _, scheme := range []string{"xor", "ctr", "chacha", "gcm", "cbc"} {
+		store.put(scheme+".bin", encryptObject(t, scheme, plain), "application/octet-stream", map[string]string{"encryption": scheme, "key-id": "old"})
+
+		for _, target := range []string{"/" + scheme + "/" + scheme + ".bin", "/file/" + scheme + ".bin"} {
+			w := serve(h, http.MethodGet, target, nil)
+			if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+				t.Errorf("%s: status %d, body matches %v", target, w.Code, bytes.Equal(w.Body.Bytes(), plain))
+			}
+		}
+	}
+
+	// uploads are tagged with the default key and served back with it
+	for _, scheme := range []string{"xor", "ctr", "chacha"} {
+		target := "/" + scheme + "/up.bin"
+		if w := serve(h, http.MethodPut, target, bytes.NewReader(plain)); w.Code != http.StatusCreated {
+			t.Fatalf("%s: upload status %d", scheme, w.Code)
+		}
+		w := serve(h, http.MethodGet, target, nil)
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+			t.Errorf("%s: upload served back with status %d", scheme, w.Code)
+		}
+	}
+
+	store.put("missing-key.bin", encryptObject(t, "ctr", plain), "application/octet-stream", map[string]string{"key-id": "gone"})
+	if w := serve(h, http.MethodGet, "/ctr/missing-key.bin", nil); w.Code != http.StatusInternalServerError {
+		t.Errorf("unknown key id: status %d, want 500", w.Code)
+	}
+}
+
+// blockingStore serves bodies that return their first bytes and then block
+// on Read until closed, like an s3 connection that stalls mid-download.
+type blockingStore struct {
+	*memStore
+	body *blockingBody
+}
+
+func (s blockingStore) GetRangeObject(ctx context.Context, bucket string, objectKey string, versionID string, ifMatch string, requestedRange string) (*s3.GetObjectOutput, error) {
+	out, err := s.memStore.GetRangeObject(ctx, bucket, objectKey, versionID, ifMatch, requestedRange)
+	if err != nil {
+		return nil, err
+	}
+	s.body.first = out.Body
+	out.Body = s.body
+	return out, nil
+}
+
+type blockingBody struct {
+	first   io.ReadCloser
+	blocked chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newBlockingBody() *blockingBody {
+	return &blockingBody{blocked: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (b *blockingBody) Read(p []byte) (int, error) {
+	if b.first != nil {
+		n, err := b.first.Read(p[:min(len(p), 100)])
+		b.first = nil
+		return n, err
+	}
+	select {
+	case <-b.blocked:
+	default:
+		close(b.blocked)
+	}
+	<-b.closed
+	return 0, net.ErrClosed
+}
+
+func (b *blockingBody) Close() error {
+	b.once.Do(func() { close(b.closed) })
+	return nil
+}
+
+// TestServeClosesOnCancel cancels a download whose s3 body blocks mid-copy,
+// the body is closed and the handler returns instead of waiting on it.
+func TestServeClosesOnCancel(t *testing.T) {
+	store := newMemStore()
+	store.put("a.txt", testPlaintext(1000), "text/plain", nil)
+	body := newBlockingBody()
+	h := newTestServer(t, blockingStore{store, body}, func(opts *serverOptions) {
+		opts.contentCacheBytes = 0
+		opts.parallelParts = 0
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/raw/a.txt", nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeRawFile(httptest.NewRecorder(), r)
+	}()
+
+	select {
+	case <-body.blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the copy never reached the blocking read")
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler did not return after the request was canceled")
+	}
+	select {
+	case <-body.closed:
+	default:
+		t.Error("the s3 body was not closed")
+	}
+}
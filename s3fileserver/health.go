@@ -0,0 +1,44 @@
+package s3fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+const readinessTimeout = 5 * time.Second
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ServeLiveness reports that the process is up.
+func ServeLiveness(w http.ResponseWriter, r *http.Request) {
+	writeHealthResponse(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// ServeReadiness reports whether every configured bucket is reachable.
+func (h HTTPFileServer) ServeReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	for _, bucket := range h.buckets.all() {
+		if err := h.s3Client.Ping(ctx, bucket); err != nil {
+			writeHealthResponse(w, http.StatusServiceUnavailable, healthResponse{Status: "unavailable", Error: "s3 unreachable"})
+			log.Printf("readiness check failed, bucket: %s, err: %v\n", bucket, err)
+			return
+		}
+	}
+
+	writeHealthResponse(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+func writeHealthResponse(w http.ResponseWriter, status int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
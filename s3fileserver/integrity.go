@@ -0,0 +1,88 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// writeBody writes the response status and streams the plaintext from
+// reader. When the whole object is served, an hmac key is configured and the
+// object has an hmac tag, the plaintext is verified against the tag.
+//
+// Objects up to hmacStrictMaxSize are buffered and verified before anything
+// is sent, so a mismatch is answered with a 502. Larger objects are streamed
+// and the final byte is held back until the hmac is known; on a mismatch the
+// connection is aborted so the client sees a truncated response instead of a
+// complete one.
+func (h HTTPFileServer) writeBody(w http.ResponseWriter, r *http.Request, status int, objKey string, reader io.Reader, contentLength int64, whole bool, macTag string) {
+	if len(h.hmacKey) == 0 || macTag == "" || !whole || contentLength <= 0 {
+		w.WriteHeader(status)
+
+		// serve the file
+		if _, err := h.buffers.copy(w, reader); err != nil {
+			slog.ErrorContext(r.Context(), "failed to serve file", "object_key", objKey, "err", err)
+		}
+		return
+	}
+
+	expected, err := hex.DecodeString(macTag)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "invalid hmac tag", "object_key", objKey, "err", err)
+		http.Error(w, "invalid hmac tag", http.StatusBadGateway)
+		return
+	}
+	mac := hmac.New(sha256.New, h.hmacKey)
+	reader = io.TeeReader(reader, mac)
+
+	// strict mode, verify before sending anything
+	if contentLength <= h.hmacStrictMaxSize {
+		buf := bytes.NewBuffer(make([]byte, 0, contentLength))
+		if _, err := io.Copy(buf, reader); err != nil {
+			http.Error(w, fmt.Sprintf("failed to read file, err: %v", err), http.StatusBadGateway)
+			return
+		}
+		if !hmac.Equal(mac.Sum(nil), expected) {
+			slog.ErrorContext(r.Context(), "integrity check failed", "object_key", objKey)
+			http.Error(w, "integrity check failed", http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(status)
+		if _, err := buf.WriteTo(w); err != nil {
+			slog.ErrorContext(r.Context(), "failed to serve file", "object_key", objKey, "err", err)
+		}
+		return
+	}
+
+	w.WriteHeader(status)
+
+	// serve all but the last byte
+	n, err := h.buffers.copy(w, io.LimitReader(reader, contentLength-1))
+	if err == nil && n < contentLength-1 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to serve file", "object_key", objKey, "err", err)
+		return
+	}
+	last, err := io.ReadAll(reader)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to serve file", "object_key", objKey, "err", err)
+		return
+	}
+
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		slog.ErrorContext(r.Context(), "integrity check failed, aborting response", "object_key", objKey)
+		panic(http.ErrAbortHandler)
+	}
+
+	if _, err := w.Write(last); err != nil {
+		slog.ErrorContext(r.Context(), "failed to serve file", "object_key", objKey, "err", err)
+	}
+}
@@ -0,0 +1,76 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var testHMACKey = []byte("hmac key")
+
+func testHMAC(plain []byte) string {
+	mac := hmac.New(sha256.New, testHMACKey)
+	mac.Write(plain)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TestHMACVerification serves objects with a matching and a mismatching
+// hmac tag, buffered under the strict size and streamed above it.
+func TestHMACVerification(t *testing.T) {
+	plain := testPlaintext(10 << 10)
+	store := newMemStore()
+	store.put("good.bin", encryptObject(t, "xor", plain), "application/octet-stream", map[string]string{"hmac": testHMAC(plain)})
+	store.put("bad.bin", encryptObject(t, "xor", plain), "application/octet-stream", map[string]string{"hmac": testHMAC(plain[1:])})
+
+	for _, strictMax := range []int64{0, 1 << 20} {
+		h := newTestServer(t, store, func(opts *serverOptions) {
+			opts.hmacKey = testHMACKey
+			opts.hmacStrictMaxSize = strictMax
+		})
+
+		w := serve(h, http.MethodGet, "/xor/good.bin", nil)
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+			t.Errorf("strict max %d: matching tag served with status %d", strictMax, w.Code)
+		}
+
+		// ranges are not verified
+		if w := serve(h, http.MethodGet, "/xor/bad.bin", nil, "Range", "bytes=0-99"); w.Code != http.StatusPartialContent {
+			t.Errorf("strict max %d: range of a mismatching object: status %d", strictMax, w.Code)
+		}
+	}
+
+	strict := newTestServer(t, store, func(opts *serverOptions) {
+		opts.hmacKey = testHMACKey
+		opts.hmacStrictMaxSize = 1 << 20
+	})
+	if w := serve(strict, http.MethodGet, "/xor/bad.bin", nil); w.Code != http.StatusBadGateway {
+		t.Errorf("strict mismatch: status %d, want 502", w.Code)
+	}
+}
+
+// TestHMACAbort streams a mismatching object, the response is aborted
+// before its last byte.
+func TestHMACAbort(t *testing.T) {
+	plain := testPlaintext(10 << 10)
+	store := newMemStore()
+	store.put("bad.bin", encryptObject(t, "xor", plain), "application/octet-stream", map[string]string{"hmac": testHMAC(plain[1:])})
+	h := newTestServer(t, store, func(opts *serverOptions) { opts.hmacKey = testHMACKey })
+
+	mux := http.NewServeMux()
+	h.RegisterHandlers(mux)
+	w := httptest.NewRecorder()
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want %v", err, http.ErrAbortHandler)
+		}
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain[:len(plain)-1]) {
+			t.Errorf("status %d with %d bytes, want all but the last byte", w.Code, w.Body.Len())
+		}
+	}()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/xor/bad.bin", nil))
+	t.Error("mismatching object served completely")
+}
@@ -0,0 +1,111 @@
+package s3fileserver
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// maxKeyLength is the longest key s3 accepts.
+const maxKeyLength = 1024
+
+var (
+	errMissingKey = errors.New("missing object key")
+	errInvalidKey = errors.New("invalid object key")
+	errKeyTooLong = errors.New("object key too long")
+)
+
+// normalizeKeyPrefix makes sure a non empty prefix ends in a slash so it
+// names a directory rather than the start of a key.
+func normalizeKeyPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// sanitizeKey decodes an escaped url path into an object key. Duplicate
+// slashes are collapsed and "." segments dropped, while ".." segments,
+// control characters and keys starting with a slash are rejected.
+func sanitizeKey(path string) (string, error) {
+	key, err := url.PathUnescape(path)
+	if err != nil {
+		return "", errInvalidKey
+	}
+	if key == "" {
+		return "", errMissingKey
+	}
+	if strings.HasPrefix(key, "/") || strings.ContainsFunc(key, unicode.IsControl) {
+		return "", errInvalidKey
+	}
+
+	segments := strings.Split(key, "/")
+	kept := segments[:0]
+	for i, segment := range segments {
+		switch segment {
+		case "..":
+			return "", errInvalidKey
+		case ".":
+			continue
+		case "":
+			// keep the trailing slash of a directory like key
+			if i != len(segments)-1 {
+				continue
+			}
+		}
+		kept = append(kept, segment)
+	}
+
+	key = strings.Join(kept, "/")
+	if key == "" || key == "/" {
+		return "", errMissingKey
+	}
+	if len(key) > maxKeyLength {
+		return "", errKeyTooLong
+	}
+
+	return key, nil
+}
+
+// objectKey returns the bucket and the s3 key of the path below route,
+// under the configured key prefix, after translating aliases. Keys that
+// could escape the prefix are rejected.
+func (h HTTPFileServer) objectKey(r *http.Request, route string) (string, string, error) {
+	bucket, path, err := h.buckets.route(r, strings.TrimPrefix(r.URL.EscapedPath(), route))
+	if err != nil {
+		return "", "", err
+	}
+
+	key, err := sanitizeKey(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	key, err = h.aliases.resolve(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	key = h.keyPrefix + key
+	if len(key) > maxKeyLength {
+		return "", "", errKeyTooLong
+	}
+	return bucket, key, nil
+}
+
+// writeKeyError responds to a request whose object key was rejected.
+func writeKeyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnknownBucket) || errors.Is(err, errUnknownAlias) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// objectCacheKey names an object in the head and iv caches.
+func objectCacheKey(bucket string, objKey string) string {
+	return bucket + "/" + objKey
+}
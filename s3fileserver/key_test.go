@@ -0,0 +1,54 @@
+package s3fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestObjectKeyPrefix serves keys below the configured prefix, which the
+// url cannot leave.
+func TestObjectKeyPrefix(t *testing.T) {
+	for _, prefix := range []string{"tenant", "/tenant/", "tenant/"} {
+		h := newTestServer(t, newMemStore(), func(opts *serverOptions) { opts.keyPrefix = prefix })
+
+		bucket, key, err := h.objectKey(httptest.NewRequest(http.MethodGet, "/xor/docs/a.txt", nil), "/xor/")
+		if err != nil || bucket != "bucket" || key != "tenant/docs/a.txt" {
+			t.Errorf("prefix %q: got %q %q, %v", prefix, bucket, key, err)
+		}
+		if _, _, err := h.objectKey(httptest.NewRequest(http.MethodGet, "/xor/../other/a.txt", nil), "/xor/"); err == nil {
+			t.Errorf("prefix %q: key outside the prefix accepted", prefix)
+		}
+	}
+}
+
+func TestSanitizeKey(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		err  error
+	}{
+		{path: "a.txt", want: "a.txt"},
+		{path: "docs//a.txt", want: "docs/a.txt"},
+		{path: "docs/./a.txt", want: "docs/a.txt"},
+		{path: "docs/", want: "docs/"},
+		{path: "a%20b.txt", want: "a b.txt"},
+		{path: "", err: errMissingKey},
+		{path: "./", err: errMissingKey},
+		{path: "../a.txt", err: errInvalidKey},
+		{path: "docs/../../a.txt", err: errInvalidKey},
+		{path: "docs/%2e%2e/a.txt", err: errInvalidKey},
+		{path: "%2Fetc/passwd", err: errInvalidKey},
+		{path: "a%00.txt", err: errInvalidKey},
+		{path: "a%zz.txt", err: errInvalidKey},
+		{path: strings.Repeat("a", maxKeyLength+1), err: errKeyTooLong},
+	}
+
+	for _, tt := range tests {
+		got, err := sanitizeKey(tt.path)
+		if err != tt.err || got != tt.want {
+			t.Errorf("%q: got %q, %v, want %q, %v", tt.path, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package s3fileserver
+
+import (
+	"crypto/cipher"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+// defaultKeyID is the id of the key used for objects without a key-id tag.
+const defaultKeyID = "default"
+
+// keyring holds the keys of one encryption scheme by id. Copies share the
+// keys, so keys replaced at runtime are seen by every handler.
+type keyring[T any] struct {
+	scheme    string
+	defaultID string
+	keys      *atomic.Pointer[map[string]T]
+}
+
+func newKeyring[T any](scheme string, defaultID string, keys map[string]T) keyring[T] {
+	k := keyring[T]{scheme: scheme, defaultID: defaultID, keys: new(atomic.Pointer[map[string]T])}
+	k.replace(keys)
+	return k
+}
+
+// replace swaps in a new set of keys, requests that already got a key keep
+// using it.
+func (k keyring[T]) replace(keys map[string]T) {
+	k.keys.Store(&keys)
+}
+
+// len returns the number of keys.
+func (k keyring[T]) len() int {
+	return len(*k.keys.Load())
+}
+
+// get returns the key with the given id, an empty id selects the default key.
+func (k keyring[T]) get(id string) (T, error) {
+	var zero T
+	keys := *k.keys.Load()
+	if len(keys) == 0 {
+		return zero, fmt.Errorf("no %s key configured", k.scheme)
+	}
+
+	if id == "" {
+		id = k.defaultID
+	}
+	key, ok := keys[id]
+	if !ok {
+		return zero, fmt.Errorf("unknown %s key id %q", k.scheme, id)
+	}
+
+	return key, nil
+}
+
+// loadXORKeys builds the xor keys from XOR_KEYS, a json object mapping key
+// ids to keys. XOR_KEY, when set, is added under the default id. Every key
+// is decoded with the given encoding, see decodeKey. defaultID must be one
+// of the keys unless there are none.
+func loadXORKeys(xorKey string, xorKeysJSON string, encoding string, defaultID string) (map[string][]byte, error) {
+	encoded := map[string]string{}
+	if xorKeysJSON != "" {
+		if err := json.Unmarshal([]byte(xorKeysJSON), &encoded); err != nil {
+			return nil, fmt.Errorf("invalid XOR_KEYS, expected a json object of key id to key, err: %w", err)
+		}
+	}
+
+	if xorKey != "" {
+		if _, ok := encoded[defaultKeyID]; ok {
+			return nil, fmt.Errorf("XOR_KEYS already has a %q key, XOR_KEY cannot be used with it", defaultKeyID)
+		}
+		encoded[defaultKeyID] = xorKey
+	}
+
+	keys := make(map[string][]byte, len(encoded))
+	for id, value := range encoded {
+		key, err := decodeKey(value, encoding)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode xor key %q, err: %w", id, err)
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("xor key %q is empty", id)
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[defaultID]; len(keys) != 0 && !ok {
+		return nil, fmt.Errorf("invalid XOR_DEFAULT_KEY_ID %q, no such key in XOR_KEYS", defaultID)
+	}
+
+	return keys, nil
+}
+
+// loadAESKeys builds the aes keys, aesKey decoded with the given encoding
+// under the default id. There are none when aesKey is empty.
+func loadAESKeys(aesKey string, encoding string) (map[string]cipher.Block, error) {
+	keys := map[string]cipher.Block{}
+	if aesKey == "" {
+		return keys, nil
+	}
+
+	key, err := decodeKey(aesKey, encoding)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode AES_KEY, err: %w", err)
+	}
+	block, err := NewAESCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AES_KEY, err: %w", err)
+	}
+	keys[defaultKeyID] = block
+
+	return keys, nil
+}
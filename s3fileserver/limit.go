@@ -0,0 +1,46 @@
+package s3fileserver
+
+import (
+	"net/http"
+)
+
+// concurrencyLimiter caps the number of streaming requests served at once.
+// Excess requests are turned away with a 503 instead of queueing.
+type concurrencyLimiter struct {
+	sem chan struct{}
+}
+
+// newConcurrencyLimiter returns a limiter that only counts in-flight requests
+// when maxConcurrent is not positive.
+func newConcurrencyLimiter(maxConcurrent int) concurrencyLimiter {
+	if maxConcurrent <= 0 {
+		return concurrencyLimiter{}
+	}
+
+	return concurrencyLimiter{sem: make(chan struct{}, maxConcurrent)}
+}
+
+func (l concurrencyLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// head requests never stream a body
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if l.sem != nil {
+			select {
+			case l.sem <- struct{}{}:
+				defer func() { <-l.sem }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		httpInFlightRequests.Inc()
+		defer httpInFlightRequests.Dec()
+		next.ServeHTTP(w, r)
+	})
+}
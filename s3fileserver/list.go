@@ -0,0 +1,89 @@
+package s3fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type listObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type listResponse struct {
+	Objects           []listObject `json:"objects"`
+	ContinuationToken string       `json:"continuation,omitempty"`
+}
+
+// ServeList lists the objects under the prefix query parameter. A response
+// with a continuation token has more objects, which are listed by passing
+// the token back as the continuation query parameter.
+func (h HTTPFileServer) ServeList(w http.ResponseWriter, r *http.Request) {
+	bucket, _, err := h.buckets.route(r, strings.TrimPrefix(r.URL.EscapedPath(), "/list/"))
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	prefix := h.keyPrefix + query.Get("prefix")
+
+	// the page size is capped by the configured maximum
+	maxKeys := h.listMaxKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid max-keys", http.StatusBadRequest)
+			return
+		}
+		maxKeys = min(n, h.listMaxKeys)
+	}
+
+	listOut, err := h.s3Client.ListObjects(r.Context(), bucket, prefix, query.Get("continuation"), int32(maxKeys))
+	if err != nil {
+		h.writeS3Error(w, err)
+		return
+	}
+
+	resp := listResponse{Objects: []listObject{}}
+	for _, obj := range listOut.Contents {
+		// keys are listed relative to the key prefix, like they are requested
+		key := strings.TrimPrefix(aws.ToString(obj.Key), h.keyPrefix)
+		if !h.listAllowed(key) {
+			continue
+		}
+
+		resp.Objects = append(resp.Objects, listObject{
+			Key:          key,
+			Size:         aws.ToInt64(obj.Size),
+			LastModified: aws.ToTime(obj.LastModified),
+		})
+	}
+	if aws.ToBool(listOut.IsTruncated) {
+		resp.ContinuationToken = aws.ToString(listOut.NextContinuationToken)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// listAllowed reports whether a key may be listed, every key is allowed
+// when no prefixes are configured.
+func (h HTTPFileServer) listAllowed(key string) bool {
+	if len(h.listPrefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range h.listPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
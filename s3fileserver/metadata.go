@@ -0,0 +1,271 @@
+package s3fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// objectMeta is the subset of the head object response the handlers need.
+type objectMeta struct {
+	bucket        string
+	contentLength int64
+	contentType   string
+	etag          string
+	lastModified  time.Time
+	versionID     string
+}
+
+var errMissingContentLength = errors.New("s3 response is missing the content length")
+
+// newObjectMeta fills in what s3 may leave out, objects stored without a
+// content type are served as application/octet-stream.
+func newObjectMeta(headObj *s3.HeadObjectOutput) (objectMeta, error) {
+	if headObj.ContentLength == nil {
+		return objectMeta{}, errMissingContentLength
+	}
+
+	contentType := aws.ToString(headObj.ContentType)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return objectMeta{
+		contentLength: *headObj.ContentLength,
+		contentType:   contentType,
+		etag:          aws.ToString(headObj.ETag),
+		lastModified:  aws.ToTime(headObj.LastModified),
+		versionID:     aws.ToString(headObj.VersionId),
+	}, nil
+}
+
+// ivs never change for a given object version, the ttl only bounds how long
+// an entry of a deleted object lingers
+const ivCacheTTL = time.Hour
+
+// cachedIV is the ctr iv or chacha nonce of an object, tied to the etag it
+// was read from.
+type cachedIV struct {
+	etag string
+	iv   []byte
+}
+
+// headObject returns the object metadata, from the head cache when possible.
+// An empty versionID is the current version. A missing object is remembered
+// in the negative cache for its short ttl.
+func (h HTTPFileServer) headObject(ctx context.Context, bucket string, objKey string, versionID string) (objectMeta, error) {
+	cacheKey := objectCacheKey(bucket, objKey)
+	if versionID != "" {
+		cacheKey += "?versionId=" + versionID
+	}
+	if h.headCache != nil {
+		meta, ok := h.headCache.Get(cacheKey)
+		observeCacheLookup("head", ok)
+		if ok {
+			return meta, nil
+		}
+	}
+
+	// keys recently found missing are not looked up again
+	if h.missCache != nil {
+		err, ok := h.missCache.Get(cacheKey)
+		observeCacheLookup("negative", ok)
+		if ok {
+			return objectMeta{}, err
+		}
+	}
+
+	headObj, err := h.s3Client.HeadObject(ctx, bucket, objKey, versionID)
+	if err != nil {
+		if mapS3Error(err) == http.StatusNotFound {
+			h.missCache.Set(cacheKey, err)
+		}
+		return objectMeta{}, err
+	}
+
+	meta, err := newObjectMeta(headObj)
+	if err != nil {
+		return objectMeta{}, err
+	}
+	meta.bucket = bucket
+	meta.contentType = h.contentTypes.resolve(objKey, meta.contentType)
+	h.headCache.Set(cacheKey, meta)
+	return meta, nil
+}
+
+// objectInfo returns the metadata and tags of the object version named by
+// the versionId query parameter, or of the current version. On failure the
+// error response has been written and ok is false. The data of the object
+// must be read with meta.versionID so it matches the metadata.
+func (h HTTPFileServer) objectInfo(w http.ResponseWriter, r *http.Request, bucket string, objKey string) (meta objectMeta, tagMap map[string]string, ok bool) {
+	versionID := r.URL.Query().Get("versionId")
+
+	// get the file size
+	meta, err := h.headObject(r.Context(), bucket, objKey, versionID)
+	if err != nil {
+		// s3 rejects a malformed version id instead of reporting it missing
+		var apiErr smithy.APIError
+		if versionID != "" && errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidArgument" {
+			http.Error(w, "no such version", http.StatusNotFound)
+			return objectMeta{}, nil, false
+		}
+
+		h.writeS3Error(w, err)
+		return objectMeta{}, nil, false
+	}
+
+	// get the object tags
+	tagMap, err = h.s3Client.GetObjectTagging(r.Context(), bucket, objKey, meta.versionID)
+	if err != nil {
+		h.writeS3Error(w, err)
+		return objectMeta{}, nil, false
+	}
+
+	if meta.versionID != "" {
+		w.Header().Set("X-Version-Id", meta.versionID)
+	}
+	return meta, tagMap, true
+}
+
+// getRange gets the bytes start to end of the object described by meta. The
+// read is pinned to meta.etag, so a range of an object overwritten since its
+// metadata was read fails with a PreconditionFailed error instead of mixing
+// data of two versions, like an iv of one and the ciphertext of another. The
+// stale cached metadata is dropped on such a failure.
+//
+// A body whose connection drops is resumed with a new get from the first
+// byte not read yet, so the readers decrypting it see one continuous stream.
+func (h HTTPFileServer) getRange(ctx context.Context, objKey string, meta objectMeta, start int64, end int64) (*s3.GetObjectOutput, error) {
+	getObj, err := h.getObject(ctx, objKey, meta, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	getObj.Body = newResumableReader(ctx, getObj.Body, start, h.readRetries, func(offset int64) (io.ReadCloser, error) {
+		// a body dropped after its last byte has nothing left to get, and s3
+		// would reject the empty range
+		if offset > end {
+			return http.NoBody, nil
+		}
+		resumed, err := h.getObject(ctx, objKey, meta, offset, end)
+		if err != nil {
+			return nil, err
+		}
+		return resumed.Body, nil
+	})
+	return getObj, nil
+}
+
+func (h HTTPFileServer) getObject(ctx context.Context, objKey string, meta objectMeta, start int64, end int64) (*s3.GetObjectOutput, error) {
+	getObj, err := h.s3Client.GetRangeObject(ctx, meta.bucket, objKey, meta.versionID, meta.etag, fmt.Sprintf("bytes=%d-%d", start, end))
+	if err != nil {
+		if mapS3Error(err) == http.StatusPreconditionFailed {
+			h.headCache.Remove(objectCacheKey(meta.bucket, objKey))
+		}
+		return nil, err
+	}
+
+	return getObj, nil
+}
+
+// getIV returns a copy of the size byte iv stored at the start of the object,
+// from the iv cache when the object has not changed since it was cached.
+func (h HTTPFileServer) getIV(ctx context.Context, objKey string, meta objectMeta, size int) ([]byte, error) {
+	// without an etag a changed object could not be told apart
+	if cached, ok := h.ivCache.Get(objectCacheKey(meta.bucket, objKey)); ok && meta.etag != "" && cached.etag == meta.etag && len(cached.iv) == size {
+		return append([]byte(nil), cached.iv...), nil
+	}
+
+	ivObj, err := h.getRange(ctx, objKey, meta, 0, int64(size)-1)
+	if err != nil {
+		return nil, err
+	}
+	defer ivObj.Body.Close()
+
+	iv := make([]byte, size)
+	if _, err := io.ReadFull(ivObj.Body, iv); err != nil {
+		return nil, err
+	}
+	h.cacheIV(objKey, meta, iv)
+
+	return iv, nil
+}
+
+// cacheIV keeps a copy of the iv read from an object, unless the object has
+// no etag to check the cached iv against.
+func (h HTTPFileServer) cacheIV(objKey string, meta objectMeta, iv []byte) {
+	if meta.etag == "" {
+		return
+	}
+	h.ivCache.Set(objectCacheKey(meta.bucket, objKey), cachedIV{etag: meta.etag, iv: append([]byte(nil), iv...)})
+}
+
+// sharedIV returns a func getting the size byte iv of the object once for
+// all ranges of a request, even when they are fetched in parallel.
+func (h HTTPFileServer) sharedIV(ctx context.Context, objKey string, meta objectMeta, size int) func() ([]byte, error) {
+	var mu sync.Mutex
+	var iv []byte
+	return func() ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if iv == nil {
+			var err error
+			if iv, err = h.getIV(ctx, objKey, meta, size); err != nil {
+				return nil, err
+			}
+		}
+		return iv, nil
+	}
+}
+
+// ivParts returns the opener of plaintext ranges of an object stored as an
+// iv of ivSize bytes followed by the ciphertext, newReader decrypts the
+// ciphertext from offset on. A range from the start of the file reads the
+// iv along with the data in a single request, any other range needs the iv
+// first.
+func (h HTTPFileServer) ivParts(ctx context.Context, objKey string, meta objectMeta, ivSize int, newReader func(body io.Reader, iv []byte, offset int64) (io.Reader, error)) func(rng byteRange) (io.ReadCloser, error) {
+	objectIV := h.sharedIV(ctx, objKey, meta, ivSize)
+
+	return func(rng byteRange) (io.ReadCloser, error) {
+		var iv []byte
+		storageStart := rng.start + int64(ivSize)
+		if rng.start == 0 {
+			storageStart = 0
+		} else {
+			var err error
+			if iv, err = objectIV(); err != nil {
+				return nil, err
+			}
+		}
+
+		getObj, err := h.getRange(ctx, objKey, meta, storageStart, rng.end+int64(ivSize))
+		if err != nil {
+			return nil, err
+		}
+
+		if iv == nil {
+			iv = make([]byte, ivSize)
+			if _, err := io.ReadFull(getObj.Body, iv); err != nil {
+				getObj.Body.Close()
+				return nil, fmt.Errorf("failed to read iv, err: %w", err)
+			}
+			h.cacheIV(objKey, meta, iv)
+		}
+
+		reader, err := newReader(getObj.Body, iv, rng.start)
+		if err != nil {
+			getObj.Body.Close()
+			return nil, err
+		}
+		return readCloser{io.LimitReader(reader, rng.length()), getObj.Body}, nil
+	}
+}
@@ -0,0 +1,115 @@
+package s3fileserver
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "file_server_http_requests_total",
+		Help: "Number of http requests by endpoint and status code.",
+	}, []string{"endpoint", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "file_server_http_request_duration_seconds",
+		Help:    "Time taken to serve http requests by endpoint, including streaming the body.",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"endpoint"})
+
+	httpResponseBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "file_server_http_response_bytes_total",
+		Help: "Number of response body bytes served by endpoint.",
+	}, []string{"endpoint"})
+
+	httpInFlightRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "file_server_http_in_flight_requests",
+		Help: "Number of file requests currently being served.",
+	})
+
+	s3RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "file_server_s3_request_duration_seconds",
+		Help:    "Time taken by s3 calls by operation, up to the response headers for reads.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	s3RequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "file_server_s3_request_errors_total",
+		Help: "Number of failed s3 calls by operation.",
+	}, []string{"operation"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "file_server_cache_lookups_total",
+		Help: "Number of metadata, negative and content cache lookups by cache and result, hit or miss.",
+	}, []string{"cache", "result"})
+)
+
+// observeCacheLookup counts a lookup of an enabled cache.
+func observeCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+func observeS3Request(operation string, start time.Time, err error) {
+	s3RequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s3RequestErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// instrumentHandler records request count, latency and bytes served for an
+// endpoint.
+func instrumentHandler(endpoint string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		httpRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+		httpRequestsTotal.WithLabelValues(endpoint, strconv.Itoa(rec.Status())).Inc()
+		httpResponseBytes.WithLabelValues(endpoint).Add(float64(rec.bytes))
+	})
+}
+
+// statusRecorder captures the status code and body size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the response status, 200 when the handler never wrote one.
+func (rec *statusRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,59 @@
+package s3fileserver
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+)
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// serveMultipartRanges writes a multipart/byteranges response with one part
+// per range. openPart returns the decrypted bytes of a single range.
+func (h HTTPFileServer) serveMultipartRanges(w http.ResponseWriter, r *http.Request, ranges []byteRange, size int64, contentType string, openPart func(rng byteRange) (io.ReadCloser, error)) {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+
+	for i, rng := range ranges {
+		part, err := openPart(rng)
+		if err != nil {
+			// nothing has been written yet, so the error can still be reported
+			if i == 0 {
+				w.Header().Del("Content-Type")
+				h.writeS3Error(w, err)
+				return
+			}
+			// the 206 is out, reset the connection so the missing closing
+			// boundary is not mistaken for a complete response
+			slog.ErrorContext(r.Context(), "failed to open range part", "start", rng.start, "end", rng.end, "err", err)
+			panic(http.ErrAbortHandler)
+		}
+
+		if i == 0 {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+
+		partWriter, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.end, size)},
+		})
+		if err == nil {
+			_, err = h.buffers.copy(partWriter, io.LimitReader(part, rng.length()))
+		}
+		part.Close()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to serve range part", "start", rng.start, "end", rng.end, "err", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		slog.ErrorContext(r.Context(), "failed to close multipart writer", "err", err)
+	}
+}
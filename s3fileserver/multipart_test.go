@@ -0,0 +1,100 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestServeMultipartRanges(t *testing.T) {
+	plain := testPlaintext(1000)
+	store := newMemStore()
+	store.put("a.txt", encryptObject(t, "xor", plain), "text/plain", nil)
+	h := newTestServer(t, store, nil)
+
+	w := serve(h, http.MethodGet, "/xor/a.txt", nil, "Range", "bytes=0-9, 500-599, -10")
+	if w.Code != http.StatusPartialContent || w.Header().Get("Content-Length") != "" {
+		t.Fatalf("status %d, Content-Length %q", w.Code, w.Header().Get("Content-Length"))
+	}
+	_, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		contentRange string
+		data         []byte
+	}{
+		{"bytes 0-9/1000", plain[:10]},
+		{"bytes 500-599/1000", plain[500:600]},
+		{"bytes 990-999/1000", plain[990:]},
+	}
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	for i, part := range want {
+		p, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Header.Get("Content-Type") != "text/plain" || p.Header.Get("Content-Range") != part.contentRange {
+			t.Errorf("part %d: headers %v", i, p.Header)
+		}
+		data, err := io.ReadAll(p)
+		if err != nil || !bytes.Equal(data, part.data) {
+			t.Errorf("part %d differs from the plaintext, %v", i, err)
+		}
+	}
+}
+
+// TestServeMultipartRangesErrors reports a failure of the first part with
+// its status, a later failure aborts the response after the parts sent.
+func TestServeMultipartRangesErrors(t *testing.T) {
+	h := newTestServer(t, newMemStore(), nil)
+	ranges := []byteRange{{0, 9}, {20, 29}}
+	r := httptest.NewRequest(http.MethodGet, "/xor/a.txt", nil)
+
+	w := httptest.NewRecorder()
+	h.serveMultipartRanges(w, r, ranges, 100, "text/plain", func(rng byteRange) (io.ReadCloser, error) {
+		return nil, &types.NoSuchKey{}
+	})
+	if w.Code != http.StatusNotFound || strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/") {
+		t.Errorf("first part: status %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	tests := []struct {
+		name string
+		// second opens the second part
+		second func() (io.ReadCloser, error)
+	}{
+		{name: "open", second: func() (io.ReadCloser, error) { return nil, errors.New("dropped") }},
+		{name: "copy", second: func() (io.ReadCloser, error) {
+			return io.NopCloser(io.MultiReader(bytes.NewReader(make([]byte, 5)), errReader{io.ErrUnexpectedEOF})), nil
+		}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		func() {
+			defer func() {
+				if err := recover(); err != http.ErrAbortHandler {
+					t.Errorf("%s: recovered %v, want %v", tt.name, err, http.ErrAbortHandler)
+				}
+			}()
+			h.serveMultipartRanges(w, r, ranges, 100, "text/plain", func(rng byteRange) (io.ReadCloser, error) {
+				if rng.start > 0 {
+					return tt.second()
+				}
+				return io.NopCloser(bytes.NewReader(make([]byte, 10))), nil
+			})
+		}()
+		if w.Code != http.StatusPartialContent || strings.HasSuffix(strings.TrimSpace(w.Body.String()), "--") {
+			t.Errorf("%s: status %d, body %q", tt.name, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package s3fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// corruptObjectError is the error of an object that cannot hold a valid
+// plaintext. It is answered with a 500 without Accept-Ranges.
+type corruptObjectError string
+
+func (e corruptObjectError) Error() string {
+	return string(e)
+}
+
+// objectFormat describes how the plaintext of an object is stored, the rest
+// of serving it is the same for every scheme.
+type objectFormat struct {
+	// scheme names the encryption, objects are cached by it
+	scheme string
+	// plaintextSize returns the size of the plaintext held by an object of
+	// fileSize bytes, checked before any data is read
+	plaintextSize func(fileSize int64) (int64, error)
+	// exactSize, when set, replaces that size once the conditional checks
+	// passed, for schemes that must read the object to know it
+	exactSize func() (int64, error)
+	// openPart returns the plaintext of a range, limited to its length
+	openPart func(rng byteRange) (io.ReadCloser, error)
+}
+
+// serveObject serves the plaintext of an object in the given format. It
+// handles the conditional headers, single, multiple and parallel ranges,
+// head requests, download limits and the integrity check.
+func (h HTTPFileServer) serveObject(w http.ResponseWriter, r *http.Request, objKey string, meta objectMeta, tagMap map[string]string, format objectFormat) {
+	// objects stored gzip compressed are sent encoded or decompressed
+	w, done, ok := h.storedEncoding(w, r, tagMap)
+	if !ok {
+		return
+	}
+	defer done()
+
+	realFileSize, err := format.plaintextSize(meta.contentLength)
+	if err != nil {
+		h.writeObjectError(w, err)
+		return
+	}
+
+	// get the original file etag, falling back to the s3 etag
+	etag := meta.etag
+	if tag, ok := tagMap["File-Checksum-Original"]; ok {
+		etag = tag
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	setContentDisposition(w, r, tagMap)
+	h.setCacheControl(w, tagMap)
+
+	// evaluate conditional request headers before fetching any data
+	if !checkPreconditions(w, r, etag, meta.lastModified) {
+		return
+	}
+
+	if format.exactSize != nil {
+		realFileSize, err = format.exactSize()
+		if err != nil {
+			h.writeObjectError(w, err)
+			return
+		}
+	}
+
+	// an object holding no plaintext, like only an iv, is an empty file
+	if realFileSize == 0 {
+		writeEmptyFile(w, r, meta)
+		return
+	}
+
+	// get range request header
+	var start int64 = 0
+	var end int64 = realFileSize - 1
+	var isPartial bool = false
+	var ranges []byteRange
+
+	// range handling is not defined for head requests, so the header is ignored
+	requestedRange := rangeHeader(r, etag, meta.lastModified)
+	if requestedRange != "" && r.Method != http.MethodHead {
+		ranges, err = parseRanges(requestedRange, realFileSize)
+		switch {
+		case errors.Is(err, errTooManyRanges):
+			// too many ranges to be worth serving apart, the whole file is sent
+			ranges = nil
+		case err != nil:
+			writeRangeNotSatisfiable(w, realFileSize)
+			return
+		default:
+			start, end = ranges[0].start, ranges[0].end
+
+			// a single range covering the whole file is served as a plain 200
+			isPartial = !coversWholeFile(ranges, realFileSize)
+		}
+	}
+	if start > end || start >= realFileSize {
+		writeRangeNotSatisfiable(w, realFileSize)
+		return
+	}
+
+	// a head request only needs the headers, skip fetching the body
+	if r.Method == http.MethodHead {
+		// but an untyped object is typed from its first bytes, so head and
+		// get send the same type
+		contentType := meta.contentType
+		if sniffable(contentType, tagMap, 0) {
+			contentType, err = sniffObject(realFileSize, format.openPart)
+			if err != nil {
+				h.writeS3Error(w, err)
+				return
+			}
+		}
+
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", realFileSize))
+		setLastModified(w, meta.lastModified)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// refuse downloads over the configured limit before fetching any data
+	if !h.downloadLimit.allow(w, r, requestedLength(ranges, realFileSize)) {
+		return
+	}
+
+	// small objects are read once into the content cache and then served
+	// from memory, ranges included
+	openPart := h.cachedParts(objKey, meta, format.scheme, realFileSize, format.openPart)
+
+	// serve multiple ranges as a multipart response
+	if len(ranges) > 1 {
+		w.Header().Set("Accept-Ranges", "bytes")
+		setLastModified(w, meta.lastModified)
+		h.serveMultipartRanges(w, r, ranges, realFileSize, meta.contentType, openPart)
+		return
+	}
+
+	if h.useParallel(end - start + 1) {
+		h.serveParallel(w, r, objKey, meta, tagMap, start, end, realFileSize, isPartial, openPart)
+		return
+	}
+
+	reader, err := openPart(byteRange{start: start, end: end})
+	if err != nil {
+		h.writeS3Error(w, err)
+		return
+	}
+	defer reader.Close()
+	defer closeOnCancel(r.Context(), reader)()
+
+	// the type of an untyped object is only known once decrypted
+	var body io.Reader = reader
+	contentType := meta.contentType
+	if sniffable(contentType, tagMap, start) {
+		contentType, body, err = sniffContentType(reader)
+		if err != nil {
+			h.writeS3Error(w, err)
+			return
+		}
+	}
+
+	// calculate content length
+	contentLength := end - start + 1
+
+	// write headers
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", contentLength))
+	setLastModified(w, meta.lastModified)
+
+	status := http.StatusOK
+	if isPartial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, realFileSize))
+		status = http.StatusPartialContent
+	}
+
+	// verify the plaintext against the hmac tag when the whole file is served
+	whole := start == 0 && end == realFileSize-1
+	h.writeBody(w, r, status, objKey, body, contentLength, whole, tagMap["hmac"])
+}
+
+// writeObjectError answers a request whose object could not be sized,
+// corrupt objects have no range to serve.
+func (h HTTPFileServer) writeObjectError(w http.ResponseWriter, err error) {
+	var corrupt corruptObjectError
+	if errors.As(err, &corrupt) {
+		w.Header().Set("Accept-Ranges", "none")
+		http.Error(w, corrupt.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeS3Error(w, err)
+}
+
+// plainSize is the plaintext size of an object storing nothing else.
+func plainSize(fileSize int64) (int64, error) {
+	return fileSize, nil
+}
+
+// storedSize returns the plaintext size of a scheme storing the plaintext
+// behind a header of the given size and name, like a 16 byte iv.
+func storedSize(scheme string, header int64, name string) func(fileSize int64) (int64, error) {
+	return func(fileSize int64) (int64, error) {
+		if fileSize < header {
+			return 0, corruptObjectError(fmt.Sprintf("corrupt %s object, smaller than the %s", scheme, name))
+		}
+		return fileSize - header, nil
+	}
+}
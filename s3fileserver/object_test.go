@@ -0,0 +1,251 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TestServeObjectParity runs every scheme through the requests serveObject
+// handles, each must answer them the same way.
+func TestServeObjectParity(t *testing.T) {
+	// spans several gcm chunks and many cbc blocks
+	plain := testPlaintext(3*gcmChunkSize + 100)
+
+	schemes := []struct {
+		name      string
+		scheme    string
+		configure func(opts *serverOptions)
+	}{
+		{name: "xor", scheme: "xor"},
+		{name: "ctr", scheme: "ctr"},
+		{name: "ctr inline", scheme: "ctr", configure: func(opts *serverOptions) { opts.ctrInlineMax = 1 << 30 }},
+		{name: "chacha", scheme: "chacha"},
+		{name: "gcm", scheme: "gcm"},
+		{name: "cbc", scheme: "cbc"},
+	}
+
+	for _, s := range schemes {
+		t.Run(s.name, func(t *testing.T) {
+			store := newMemStore()
+			store.put("a.bin", encryptObject(t, s.scheme, plain), "application/octet-stream", nil)
+			store.put("empty.bin", encryptObject(t, s.scheme, nil), "application/octet-stream", nil)
+			h := newTestServer(t, store, s.configure)
+			target := "/" + s.scheme + "/a.bin"
+
+			t.Run("full", func(t *testing.T) {
+				w := serve(h, http.MethodGet, target, nil)
+				if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+					t.Fatalf("status %d, body matches %v", w.Code, bytes.Equal(w.Body.Bytes(), plain))
+				}
+				if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(plain)) {
+					t.Errorf("Content-Length %q", got)
+				}
+			})
+
+			t.Run("ranged", func(t *testing.T) {
+				for _, rng := range [][2]int{{0, 0}, {1, 16}, {15, 17}, {gcmChunkSize - 1, gcmChunkSize}, {len(plain) - 1, len(plain) - 1}} {
+					header := "bytes=" + strconv.Itoa(rng[0]) + "-" + strconv.Itoa(rng[1])
+					w := serve(h, http.MethodGet, target, nil, "Range", header)
+					if w.Code != http.StatusPartialContent {
+						t.Fatalf("%s: status %d", header, w.Code)
+					}
+					if !bytes.Equal(w.Body.Bytes(), plain[rng[0]:rng[1]+1]) {
+						t.Errorf("%s: body differs from the plaintext", header)
+					}
+				}
+			})
+
+			t.Run("multipart", func(t *testing.T) {
+				w := serve(h, http.MethodGet, target, nil, "Range", "bytes=0-9,100-199,-5")
+				if w.Code != http.StatusPartialContent {
+					t.Fatalf("status %d", w.Code)
+				}
+				mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+				if err != nil || mediaType != "multipart/byteranges" {
+					t.Fatalf("Content-Type %q", w.Header().Get("Content-Type"))
+				}
+
+				want := [][]byte{plain[0:10], plain[100:200], plain[len(plain)-5:]}
+				reader := multipart.NewReader(w.Body, params["boundary"])
+				for i := range want {
+					part, err := reader.NextPart()
+					if err != nil {
+						t.Fatal(err)
+					}
+					got, err := io.ReadAll(part)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, want[i]) {
+						t.Errorf("part %d differs from the plaintext", i)
+					}
+				}
+				if _, err := reader.NextPart(); err != io.EOF {
+					t.Errorf("more parts than ranges, err %v", err)
+				}
+			})
+
+			t.Run("head", func(t *testing.T) {
+				w := serve(h, http.MethodHead, target, nil)
+				if w.Code != http.StatusOK || w.Body.Len() != 0 {
+					t.Fatalf("status %d with %d body bytes", w.Code, w.Body.Len())
+				}
+				if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(plain)) {
+					t.Errorf("Content-Length %q", got)
+				}
+				// an untyped object is sniffed like a get does
+				if got, want := w.Header().Get("Content-Type"), serve(h, http.MethodGet, target, nil).Header().Get("Content-Type"); got != want {
+					t.Errorf("Content-Type %q, a get sends %q", got, want)
+				}
+
+				// only cbc reads its last blocks to size the plaintext, a
+				// typed object needs no sniffing
+				store.put("typed.bin", encryptObject(t, s.scheme, plain), "text/plain", nil)
+				gets := store.getCount()
+				w = serve(h, http.MethodHead, "/"+s.scheme+"/typed.bin", nil)
+				if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/plain" {
+					t.Errorf("typed object: status %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+				}
+				if s.scheme != "cbc" && store.getCount() != gets {
+					t.Error("head request read a typed object")
+				}
+			})
+
+			t.Run("empty", func(t *testing.T) {
+				w := serve(h, http.MethodGet, "/"+s.scheme+"/empty.bin", nil)
+				if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "0" {
+					t.Errorf("status %d, Content-Length %q, %d body bytes", w.Code, w.Header().Get("Content-Length"), w.Body.Len())
+				}
+			})
+
+			t.Run("conditional", func(t *testing.T) {
+				etag := serve(h, http.MethodHead, target, nil).Header().Get("ETag")
+				if etag == "" {
+					t.Fatal("no etag")
+				}
+				gets := store.getCount()
+				if w := serve(h, http.MethodGet, target, nil, "If-None-Match", etag); w.Code != http.StatusNotModified {
+					t.Errorf("If-None-Match: status %d, want 304", w.Code)
+				}
+				if w := serve(h, http.MethodGet, target, nil, "If-Match", `"other"`); w.Code != http.StatusPreconditionFailed {
+					t.Errorf("If-Match: status %d, want 412", w.Code)
+				}
+				if store.getCount() != gets {
+					t.Error("a failed precondition read the object")
+				}
+			})
+		})
+	}
+}
+
+// lengthlessStore leaves the content length out of head responses.
+type lengthlessStore struct {
+	*memStore
+}
+
+func (s lengthlessStore) HeadObject(ctx context.Context, bucket string, objectKey string, versionID string) (*s3.HeadObjectOutput, error) {
+	out, err := s.memStore.HeadObject(ctx, bucket, objectKey, versionID)
+	if err != nil {
+		return nil, err
+	}
+	out.ContentLength = nil
+	return out, nil
+}
+
+// TestServeSparseMetadata serves objects of a store that leaves out the
+// content type, etag, last modified time and get lengths. Nothing may be
+// sent for what is missing, and an object replaced under the same key must
+// not be decrypted with the iv of the old one.
+func TestServeSparseMetadata(t *testing.T) {
+	plain := testPlaintext(3000)
+	checkHeaders := func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		for name, values := range w.Header() {
+			for _, value := range values {
+				if strings.TrimSpace(value) == "" {
+					t.Errorf("empty %s header", name)
+				}
+			}
+		}
+		for _, name := range []string{"ETag", "Last-Modified"} {
+			if value := w.Header().Get(name); value != "" {
+				t.Errorf("%s %q for an object s3 sent none for", name, value)
+			}
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/octet-stream" {
+			t.Errorf("Content-Type %q", got)
+		}
+	}
+
+	for _, scheme := range []string{"xor", "ctr", "chacha", "gcm", "cbc"} {
+		t.Run(scheme, func(t *testing.T) {
+			store := newMemStore()
+			store.sparse = true
+			store.put("a.bin", encryptObject(t, scheme, plain), "", nil)
+			h := newTestServer(t, store, func(opts *serverOptions) { opts.ivCacheSize = 16 })
+			target := "/" + scheme + "/a.bin"
+
+			w := serve(h, http.MethodGet, target, nil)
+			if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+				t.Fatalf("get: status %d, body matches %v", w.Code, bytes.Equal(w.Body.Bytes(), plain))
+			}
+			checkHeaders(t, w)
+
+			w = serve(h, http.MethodGet, target, nil, "Range", "bytes=100-199")
+			if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), plain[100:200]) {
+				t.Errorf("range: status %d, body matches %v", w.Code, bytes.Equal(w.Body.Bytes(), plain[100:200]))
+			}
+			checkHeaders(t, w)
+
+			w = serve(h, http.MethodHead, target, nil)
+			if w.Code != http.StatusOK || w.Header().Get("Content-Length") != strconv.Itoa(len(plain)) {
+				t.Errorf("head: status %d, Content-Length %q", w.Code, w.Header().Get("Content-Length"))
+			}
+			checkHeaders(t, w)
+
+			// the same key encrypted again has a new iv or nonce, which a
+			// range past the start must read instead of the old one
+			replaced := bytes.Repeat([]byte("replaced "), 300)
+			store.put("a.bin", encryptObject(t, scheme, replaced), "", nil)
+			if w := serve(h, http.MethodGet, target, nil, "Range", "bytes=100-199"); !bytes.Equal(w.Body.Bytes(), replaced[100:200]) {
+				t.Errorf("replaced object: status %d, body differs from the new plaintext", w.Code)
+			}
+		})
+	}
+
+	t.Run("upload and list", func(t *testing.T) {
+		store := newMemStore()
+		store.sparse = true
+		h := newTestServer(t, store, nil)
+
+		w := serve(h, http.MethodPut, "/ctr/up.bin", bytes.NewReader(plain))
+		if w.Code != http.StatusCreated || strings.Contains(w.Body.String(), `"etag":""`) {
+			t.Errorf("upload: status %d, body %s", w.Code, w.Body)
+		}
+		w = serve(h, http.MethodGet, "/list/", nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"up.bin"`) {
+			t.Errorf("list: status %d, body %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("no content length", func(t *testing.T) {
+		store := newMemStore()
+		store.put("a.bin", plain, "", nil)
+		h := newTestServer(t, lengthlessStore{store}, nil)
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			if w := serve(h, method, "/raw/a.bin", nil); w.Code < http.StatusInternalServerError {
+				t.Errorf("%s: status %d, want a server error", method, w.Code)
+			}
+		}
+	})
+}
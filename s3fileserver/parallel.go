@@ -0,0 +1,156 @@
+package s3fileserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// parallelPart is the outcome of fetching one part of a parallel download.
+type parallelPart struct {
+	data []byte
+	err  error
+}
+
+// parallelReader reads a byte range fetched as consecutive parts, up to
+// parts of them at once. Parts are buffered in memory and handed out in
+// order, so at most parts buffers are held at any time.
+type parallelReader struct {
+	queue chan chan parallelPart
+	done  chan struct{}
+	cur   []byte
+	err   error
+}
+
+// newParallelReader starts fetching rng in parts of partSize bytes.
+// openPart returns the decrypted bytes of a single part.
+func newParallelReader(rng byteRange, parts int, partSize int64, openPart func(rng byteRange) (io.ReadCloser, error)) *parallelReader {
+	r := &parallelReader{
+		// the part being read plus the queued ones make up the fetch limit
+		queue: make(chan chan parallelPart, parts-1),
+		done:  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(r.queue)
+
+		for offset := rng.start; offset <= rng.end; offset += partSize {
+			part := byteRange{start: offset, end: min(offset+partSize-1, rng.end)}
+			result := make(chan parallelPart, 1)
+
+			// wait for room in the queue, this is the back pressure
+			select {
+			case r.queue <- result:
+			case <-r.done:
+				return
+			}
+
+			go func() {
+				data, err := readPart(part, openPart)
+				result <- parallelPart{data: data, err: err}
+			}()
+		}
+	}()
+
+	return r
+}
+
+func readPart(part byteRange, openPart func(rng byteRange) (io.ReadCloser, error)) ([]byte, error) {
+	body, err := openPart(part)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data := make([]byte, part.length())
+	if _, err := io.ReadFull(body, data); err != nil {
+		return nil, fmt.Errorf("failed to read part %d-%d, err: %w", part.start, part.end, err)
+	}
+
+	return data, nil
+}
+
+// fill waits for the next part once the current one is used up.
+func (r *parallelReader) fill() error {
+	for len(r.cur) == 0 && r.err == nil {
+		result, ok := <-r.queue
+		if !ok {
+			r.err = io.EOF
+			break
+		}
+
+		part := <-result
+		r.cur, r.err = part.data, part.err
+	}
+
+	if len(r.cur) == 0 {
+		return r.err
+	}
+	return nil
+}
+
+func (r *parallelReader) Read(p []byte) (int, error) {
+	if err := r.fill(); err != nil {
+		return 0, err
+	}
+
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+// Close stops fetching new parts, parts already in flight finish on their own.
+func (r *parallelReader) Close() error {
+	close(r.done)
+	return nil
+}
+
+// useParallel reports whether a download of contentLength bytes is split
+// into parallel parts.
+func (h HTTPFileServer) useParallel(contentLength int64) bool {
+	return h.parallelParts > 1 && contentLength > h.parallelPartSize
+}
+
+// serveParallel serves the range start-end of an object of the given
+// plaintext size, fetched as parallel parts.
+func (h HTTPFileServer) serveParallel(w http.ResponseWriter, r *http.Request, objKey string, meta objectMeta, tagMap map[string]string, start, end, size int64, isPartial bool, openPart func(rng byteRange) (io.ReadCloser, error)) {
+	reader := newParallelReader(byteRange{start: start, end: end}, h.parallelParts, h.parallelPartSize, openPart)
+	defer reader.Close()
+
+	// nothing has been written yet, so a failed first part can still be reported
+	if err := reader.fill(); err != nil {
+		h.writeS3Error(w, err)
+		return
+	}
+
+	// the type of an untyped object is only known once decrypted
+	var body io.Reader = reader
+	contentType := meta.contentType
+	if sniffable(contentType, tagMap, start) {
+		var err error
+		contentType, body, err = sniffContentType(reader)
+		if err != nil {
+			h.writeS3Error(w, err)
+			return
+		}
+	}
+
+	// calculate content length
+	contentLength := end - start + 1
+
+	// write headers
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", contentLength))
+	setLastModified(w, meta.lastModified)
+
+	status := http.StatusOK
+	if isPartial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		status = http.StatusPartialContent
+	}
+
+	// verify the plaintext against the hmac tag when the whole file is served
+	whole := start == 0 && end == size-1
+	h.writeBody(w, r, status, objKey, body, contentLength, whole, tagMap["hmac"])
+}
@@ -0,0 +1,54 @@
+package s3fileserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type presignResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ServePresign returns a presigned s3 url for an unencrypted object, so
+// large downloads can go straight to s3 instead of through the server.
+// Encrypted objects have to be served by the decrypting endpoints.
+func (h HTTPFileServer) ServePresign(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/presign/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	meta, tagMap, ok := h.objectInfo(w, r, bucket, objKey)
+	if !ok {
+		return
+	}
+
+	if encryption := h.objectEncryption(tagMap); encryption != "none" {
+		http.Error(w, "object is encrypted with "+encryption+", download it through /file/ so it is decrypted", http.StatusConflict)
+		return
+	}
+
+	expiresAt := time.Now().Add(h.presignExpiry)
+	url, err := h.s3Client.PresignGetObject(r.Context(), meta.bucket, objKey, meta.versionID, h.presignExpiry)
+	if errors.Is(err, ErrPresignSSEC) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	// keep the query separators readable
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(presignResponse{URL: url, ExpiresAt: expiresAt.UTC().Truncate(time.Second)})
+}
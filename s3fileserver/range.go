@@ -0,0 +1,152 @@
+package s3fileserver
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxRanges bounds the ranges of one request after overlapping ones are
+// merged, more are answered with the whole file.
+const maxRanges = 64
+
+var (
+	errInvalidRange  = errors.New("invalid range")
+	errTooManyRanges = errors.New("too many ranges")
+)
+
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+func (rng byteRange) length() int64 {
+	return rng.end - rng.start + 1
+}
+
+// parseRanges parses a "bytes=" range header, which may hold several
+// comma separated specs, against a resource of the given size and returns
+// the inclusive byte offsets of each spec. The unit is matched case
+// insensitively and whitespace around it, the numbers and the dash is
+// ignored. It fails if the unit isn't bytes or any spec is malformed or
+// unsatisfiable.
+//
+// Overlapping and adjacent ranges are merged and the ranges sorted, as RFC
+// 9110 section 14.2 allows, so no byte is sent twice. More than maxRanges
+// ranges left after that fail with errTooManyRanges.
+func parseRanges(header string, size int64) ([]byteRange, error) {
+	unit, spec, ok := strings.Cut(header, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		start, end, err := parseRangeSpec(strings.TrimSpace(part), size)
+		if err != nil {
+			return nil, err
+		}
+		if start > end || start >= size {
+			return nil, errInvalidRange
+		}
+		ranges = append(ranges, byteRange{start: start, end: end})
+	}
+
+	ranges = coalesceRanges(ranges)
+	if len(ranges) > maxRanges {
+		return nil, errTooManyRanges
+	}
+	return ranges, nil
+}
+
+// coalesceRanges sorts ranges by their start and merges the ones that
+// overlap or touch.
+func coalesceRanges(ranges []byteRange) []byteRange {
+	slices.SortFunc(ranges, func(a, b byteRange) int {
+		return cmp.Compare(a.start, b.start)
+	})
+
+	merged := ranges[:1]
+	for _, rng := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if rng.start <= last.end+1 {
+			last.end = max(last.end, rng.end)
+			continue
+		}
+		merged = append(merged, rng)
+	}
+	return merged
+}
+
+// parseRangeSpec parses a single "start-end" spec without the unit prefix.
+func parseRangeSpec(spec string, size int64) (start, end int64, err error) {
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, errInvalidRange
+	}
+	startStr, endStr = strings.TrimSpace(startStr), strings.TrimSpace(endStr)
+
+	// a suffix range selects the last n bytes of the resource
+	if startStr == "" {
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, errInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, nil
+	}
+
+	start, err = strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errInvalidRange
+	}
+
+	// an open-ended range runs to the end of the resource
+	if endStr == "" {
+		return start, size - 1, nil
+	}
+
+	end, err = strconv.ParseInt(endStr, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, errInvalidRange
+	}
+	if end >= size {
+		end = size - 1
+	}
+
+	return start, end, nil
+}
+
+// requestedLength returns the number of bytes the ranges select, or size
+// when no ranges were requested.
+func requestedLength(ranges []byteRange, size int64) int64 {
+	if len(ranges) == 0 {
+		return size
+	}
+
+	var n int64
+	for _, rng := range ranges {
+		n += rng.length()
+	}
+	return n
+}
+
+// coversWholeFile reports whether ranges is a single range spanning the whole
+// file. Such a request is answered with a 200 rather than a 206, which some
+// clients expect for bytes=0- requests.
+func coversWholeFile(ranges []byteRange, size int64) bool {
+	return len(ranges) == 1 && ranges[0].start == 0 && ranges[0].end == size-1
+}
+
+// writeRangeNotSatisfiable answers a range request that cannot be served,
+// the Content-Range header tells the client the actual size.
+func writeRangeNotSatisfiable(w http.ResponseWriter, size int64) {
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	http.Error(w, "requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+}
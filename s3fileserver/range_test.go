@@ -0,0 +1,153 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestServeRangeBeyondEnd checks that a range ending at or past the end of
+// the plaintext is clamped and one starting past it is not satisfiable, for
+// schemes whose stored size differs from the plaintext size too.
+func TestServeRangeBeyondEnd(t *testing.T) {
+	plain := testPlaintext(100)
+
+	for _, scheme := range []string{"xor", "ctr", "gcm"} {
+		store := newMemStore()
+		store.put("a.bin", encryptObject(t, scheme, plain), "application/octet-stream", nil)
+		h := newTestServer(t, store, nil)
+
+		for _, header := range []string{"bytes=90-99", "bytes=90-100", "bytes=90-999999999"} {
+			w := serve(h, http.MethodGet, "/"+scheme+"/a.bin", nil, "Range", header)
+			if w.Code != http.StatusPartialContent {
+				t.Fatalf("%s %s: status %d, want 206", scheme, header, w.Code)
+			}
+			if got := w.Header().Get("Content-Range"); got != "bytes 90-99/100" {
+				t.Errorf("%s %s: Content-Range %q", scheme, header, got)
+			}
+			if !bytes.Equal(w.Body.Bytes(), plain[90:]) {
+				t.Errorf("%s %s: body differs from the plaintext", scheme, header)
+			}
+		}
+
+		for _, header := range []string{"bytes=100-", "bytes=100-200", "bytes=999999999-"} {
+			w := serve(h, http.MethodGet, "/"+scheme+"/a.bin", nil, "Range", header)
+			if w.Code != http.StatusRequestedRangeNotSatisfiable {
+				t.Fatalf("%s %s: status %d, want 416", scheme, header, w.Code)
+			}
+			if got := w.Header().Get("Content-Range"); got != "bytes */100" {
+				t.Errorf("%s %s: Content-Range %q, want bytes */100", scheme, header, got)
+			}
+		}
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []byteRange
+		err    bool
+	}{
+		{header: "bytes=0-99", want: []byteRange{{0, 99}}},
+		{header: "bytes=10-", want: []byteRange{{10, 99}}},
+		{header: "bytes=-10", want: []byteRange{{90, 99}}},
+		{header: "bytes=-1000", want: []byteRange{{0, 99}}},
+		{header: "bytes=50-1000", want: []byteRange{{50, 99}}},
+		{header: "bytes=99-99", want: []byteRange{{99, 99}}},
+		{header: "Bytes = 1 - 2", want: []byteRange{{1, 2}}},
+		{header: "bytes=0-0, 10-19,-5", want: []byteRange{{0, 0}, {10, 19}, {95, 99}}},
+		{header: "bytes=50-59,0-9", want: []byteRange{{0, 9}, {50, 59}}},
+		{header: "bytes=0-9,5-14", want: []byteRange{{0, 14}}},
+		{header: "bytes=0-9,10-19,30-39", want: []byteRange{{0, 19}, {30, 39}}},
+		{header: "bytes=20-29,0-99,40-", want: []byteRange{{0, 99}}},
+		{header: "bytes=-10,95-", want: []byteRange{{90, 99}}},
+		{header: "bytes=100-", err: true},
+		{header: "bytes=5-4", err: true},
+		{header: "bytes=-0", err: true},
+		{header: "bytes=-", err: true},
+		{header: "bytes=a-b", err: true},
+		{header: "bytes=-1-2", err: true},
+		{header: "bytes=0-9,", err: true},
+		{header: "items=0-9", err: true},
+		{header: "0-9", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRanges(tt.header, 100)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", tt.header, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.header, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// TestParseRangesLimit accepts up to maxRanges disjoint ranges, and counts
+// them once merged.
+func TestParseRangesLimit(t *testing.T) {
+	var specs []string
+	for i := range maxRanges {
+		specs = append(specs, fmt.Sprintf("%d-%d", 2*i, 2*i))
+	}
+	header := "bytes=" + strings.Join(specs, ",")
+
+	if got, err := parseRanges(header, 1000); err != nil || len(got) != maxRanges {
+		t.Errorf("%d ranges: got %d, %v", maxRanges, len(got), err)
+	}
+	if _, err := parseRanges(header+",200-200", 1000); err != errTooManyRanges {
+		t.Errorf("%d ranges: err %v, want %v", maxRanges+1, err, errTooManyRanges)
+	}
+	if got, err := parseRanges(header+",0-1000", 1000); err != nil || len(got) != 1 {
+		t.Errorf("ranges covered by one: got %v, %v", got, err)
+	}
+}
+
+// TestServeManyRanges merges overlapping ranges into one part and answers
+// too many ranges with the whole file.
+func TestServeManyRanges(t *testing.T) {
+	plain := testPlaintext(1000)
+	store := newMemStore()
+	store.put("a.bin", encryptObject(t, "ctr", plain), "application/octet-stream", nil)
+	h := newTestServer(t, store, nil)
+
+	w := serve(h, http.MethodGet, "/ctr/a.bin", nil, "Range", "bytes=10-19,0-14,15-29")
+	if w.Code != http.StatusPartialContent || w.Header().Get("Content-Range") != "bytes 0-29/1000" || !bytes.Equal(w.Body.Bytes(), plain[:30]) {
+		t.Errorf("overlapping ranges: status %d, Content-Range %q", w.Code, w.Header().Get("Content-Range"))
+	}
+
+	var specs []string
+	for i := range maxRanges + 1 {
+		specs = append(specs, fmt.Sprintf("%d-%d", 10*i, 10*i))
+	}
+	w = serve(h, http.MethodGet, "/ctr/a.bin", nil, "Range", "bytes="+strings.Join(specs, ","))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+		t.Errorf("too many ranges: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+}
+
+// TestServeWholeFileRange answers a range covering the whole file with a
+// 200 and no Content-Range.
+func TestServeWholeFileRange(t *testing.T) {
+	plain := testPlaintext(100)
+	store := newMemStore()
+	store.put("a.bin", encryptObject(t, "ctr", plain), "application/octet-stream", nil)
+	h := newTestServer(t, store, nil)
+
+	for _, header := range []string{"bytes=0-", "bytes=0-99", "bytes=-100", "bytes=0-1000"} {
+		w := serve(h, http.MethodGet, "/ctr/a.bin", nil, "Range", header)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Range") != "" || !bytes.Equal(w.Body.Bytes(), plain) {
+			t.Errorf("%s: status %d, Content-Range %q", header, w.Code, w.Header().Get("Content-Range"))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package s3fileserver
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitIdleTTL is how long the buckets of a client are kept after its
+// last request, a returning client starts with full buckets.
+const rateLimitIdleTTL = 10 * time.Minute
+
+// rateLimiter gives every client ip a token bucket of requests and
+// optionally one of response bytes. Over the limit requests get a 429.
+type rateLimiter struct {
+	requestsPerSec rate.Limit
+	burst          int
+	bytesPerSec    rate.Limit
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiter returns nil, which limits nothing, when both rates are not
+// positive. A burst below one allows one second worth of requests at once.
+func newRateLimiter(requestsPerSec float64, burst int, bytesPerSec int64) *rateLimiter {
+	if requestsPerSec <= 0 && bytesPerSec <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = max(1, int(math.Ceil(requestsPerSec)))
+	}
+
+	return &rateLimiter{
+		requestsPerSec: rate.Limit(requestsPerSec),
+		burst:          burst,
+		bytesPerSec:    rate.Limit(bytesPerSec),
+		clients:        make(map[string]*clientLimiter),
+		lastSweep:      time.Now(),
+	}
+}
+
+// client returns the buckets of ip, dropping the buckets of idle clients on
+// the way so the map does not grow with every address ever seen.
+func (l *rateLimiter) client(ip string, now time.Time) *clientLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitIdleTTL {
+		for key, c := range l.clients {
+			if now.Sub(c.lastSeen) > rateLimitIdleTTL {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &clientLimiter{}
+		if l.requestsPerSec > 0 {
+			c.requests = rate.NewLimiter(l.requestsPerSec, l.burst)
+		}
+		if l.bytesPerSec > 0 {
+			c.bytes = rate.NewLimiter(l.bytesPerSec, int(l.bytesPerSec))
+		}
+		l.clients[ip] = c
+	}
+	c.lastSeen = now
+	return c
+}
+
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		c := l.client(remoteIP(r.Context()), now)
+
+		// a request may start once its token is there
+		if c.requests != nil {
+			reservation := c.requests.ReserveN(now, 1)
+			if delay := reservation.DelayFrom(now); delay > 0 {
+				reservation.CancelAt(now)
+				writeRateLimited(w, delay)
+				return
+			}
+		}
+
+		if c.bytes == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the bytes of a response are charged once it is sent, a client in
+		// debt waits until the bucket has refilled
+		if tokens := c.bytes.TokensAt(now); tokens < 0 {
+			writeRateLimited(w, time.Duration(-tokens/float64(l.bytesPerSec)*float64(time.Second)))
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		for sent := rec.bytes; sent > 0; {
+			n := min(sent, int64(c.bytes.Burst()))
+			c.bytes.ReserveN(time.Now(), int(n))
+			sent -= n
+		}
+	})
+}
+
+// writeRateLimited answers with a 429 telling the client when to retry.
+func writeRateLimited(w http.ResponseWriter, delay time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(delay.Seconds())))))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
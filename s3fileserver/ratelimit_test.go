@@ -0,0 +1,52 @@
+package s3fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRateLimiter(t *testing.T) {
+	body := strings.Repeat("a", 500)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	})
+
+	do := func(l *rateLimiter, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/xor/a.bin", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		clientIPs{}.middleware(l.middleware(next)).ServeHTTP(w, r)
+		return w
+	}
+
+	requests := newRateLimiter(0.001, 2, 0)
+	for i := 0; i < 2; i++ {
+		if w := do(requests, "192.0.2.1:1000"); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: status %d", i, w.Code)
+		}
+	}
+	w := do(requests, "192.0.2.1:1001")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("over the burst: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := do(requests, "192.0.2.2:1000"); w.Code != http.StatusOK {
+		t.Errorf("another client: status %d", w.Code)
+	}
+
+	// the bytes of a response are charged afterwards, the next request of a
+	// client in debt waits
+	byteLimiter := newRateLimiter(0, 0, 100)
+	if w := do(byteLimiter, "192.0.2.1:1000"); w.Code != http.StatusOK || w.Body.Len() != len(body) {
+		t.Fatalf("first download: status %d", w.Code)
+	}
+	w = do(byteLimiter, "192.0.2.1:1000")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "4" {
+		t.Errorf("in debt: status %d, Retry-After %q, want 429 and 4", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	if newRateLimiter(0, 10, 0) != nil {
+		t.Error("limiter without rates is not disabled")
+	}
+}
@@ -0,0 +1,27 @@
+package s3fileserver
+
+import "net/http"
+
+// ServeRawFile serves an object as it is stored, for plain objects that need
+// no decryption. Objects encrypted by their tag or the default scheme are
+// refused so their ciphertext is not sent by mistake.
+func (h HTTPFileServer) ServeRawFile(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/raw/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	meta, tagMap, ok := h.objectInfo(w, r, bucket, objKey)
+	if !ok {
+		return
+	}
+
+	// an untagged object is encrypted with the default scheme
+	if encryption := h.objectEncryption(tagMap); encryption != "none" {
+		http.Error(w, "object is encrypted with "+encryption+", download it through /file/ so it is decrypted", http.StatusConflict)
+		return
+	}
+	h.serveStream(w, r, objKey, meta, tagMap, "none", plainDecrypter)
+}
@@ -0,0 +1,40 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+// TestServeRawEncryption refuses objects encrypted by their tag or by the
+// default scheme, so /raw/ never sends ciphertext.
+func TestServeRawEncryption(t *testing.T) {
+	plain := testPlaintext(100)
+	store := newMemStore()
+	store.put("untagged.bin", plain, "application/octet-stream", nil)
+	store.put("none.bin", plain, "application/octet-stream", map[string]string{"encryption": "none"})
+	store.put("xor.bin", encryptObject(t, "xor", plain), "application/octet-stream", map[string]string{"encryption": "xor"})
+
+	tests := []struct {
+		defaultEncryption string
+		key               string
+		want              int
+	}{
+		{"none", "untagged.bin", http.StatusOK},
+		{"none", "none.bin", http.StatusOK},
+		{"none", "xor.bin", http.StatusConflict},
+		{"xor", "untagged.bin", http.StatusConflict},
+		{"xor", "none.bin", http.StatusOK},
+		{"xor", "xor.bin", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		h := newTestServer(t, store, func(opts *serverOptions) { opts.defaultEncryption = tt.defaultEncryption })
+		w := serve(h, http.MethodGet, "/raw/"+tt.key, nil)
+		if w.Code != tt.want {
+			t.Errorf("default %s, %s: status %d, want %d", tt.defaultEncryption, tt.key, w.Code, tt.want)
+		}
+		if w.Code == http.StatusOK && !bytes.Equal(w.Body.Bytes(), plain) {
+			t.Errorf("default %s, %s: body differs from the object", tt.defaultEncryption, tt.key)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package s3fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// endpoints lists the endpoints RegisterHandlers mounts, in mounting order.
+var endpoints = []string{"xor", "ctr", "gcm", "chacha", "cbc", "raw", "file", "thumbnail", "list", "presign"}
+
+// Option configures RegisterHandlers.
+type Option func(*registerOptions)
+
+type registerOptions struct {
+	prefixes   map[string]string
+	enabled    map[string]bool
+	uploads    bool
+	middleware func(endpoint string, next http.Handler) http.Handler
+}
+
+// WithPrefix mounts endpoint, one of xor, ctr, gcm, chacha, cbc, raw, file,
+// thumbnail, list or presign, under prefix instead of /<endpoint>/.
+func WithPrefix(endpoint string, prefix string) Option {
+	return func(o *registerOptions) {
+		o.prefixes[endpoint] = prefix
+	}
+}
+
+// WithEndpoints mounts only the given endpoints instead of all of them.
+func WithEndpoints(names ...string) Option {
+	return func(o *registerOptions) {
+		o.enabled = make(map[string]bool)
+		for _, name := range names {
+			o.enabled[name] = true
+		}
+	}
+}
+
+// WithUploads mounts the PUT handlers of the xor, ctr and chacha endpoints.
+// Uploads are off by default.
+func WithUploads(allowed bool) Option {
+	return func(o *registerOptions) {
+		o.uploads = allowed
+	}
+}
+
+// WithMiddleware wraps every mounted handler. endpoint names the handler,
+// like xor or xor_upload, for metrics and logs.
+func WithMiddleware(middleware func(endpoint string, next http.Handler) http.Handler) Option {
+	return func(o *registerOptions) {
+		o.middleware = middleware
+	}
+}
+
+// RegisterHandlers mounts the file endpoints on mux, by default every
+// endpoint under /<endpoint>/ without uploads. The chacha endpoint is only
+// mounted when a chacha key is configured, and deleting only when allowed.
+// Requests with a method no handler is mounted for get a 405. It panics on
+// an unknown endpoint name, like mux does on a conflicting pattern.
+func (h HTTPFileServer) RegisterHandlers(mux *http.ServeMux, opts ...Option) {
+	o := registerOptions{prefixes: make(map[string]string)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	for name := range o.prefixes {
+		checkEndpoint(name)
+	}
+	for name := range o.enabled {
+		checkEndpoint(name)
+	}
+
+	handlers := map[string]http.HandlerFunc{
+		"xor":       h.ServeXORFile,
+		"ctr":       h.ServeCTRFile,
+		"gcm":       h.ServeGCMFile,
+		"chacha":    h.ServeChaChaFile,
+		"cbc":       h.ServeCBCFile,
+		"raw":       h.ServeRawFile,
+		"file":      h.ServeFile,
+		"thumbnail": h.ServeThumbnail,
+		"list":      h.ServeList,
+		"presign":   h.ServePresign,
+	}
+	uploads := map[string]http.HandlerFunc{
+		"xor":    h.UploadXORFile,
+		"ctr":    h.UploadCTRFile,
+		"chacha": h.UploadChaChaFile,
+	}
+
+	for _, name := range endpoints {
+		if o.enabled != nil && !o.enabled[name] {
+			continue
+		}
+		if name == "chacha" && h.chachaKeys.len() == 0 {
+			continue
+		}
+
+		route := "/" + name + "/"
+		prefix := route
+		if custom, ok := o.prefixes[name]; ok {
+			prefix = "/" + strings.Trim(custom, "/")
+			if prefix != "/" {
+				prefix += "/"
+			}
+		}
+
+		mount := func(method string, endpoint string, handler http.Handler) {
+			if o.middleware != nil {
+				handler = o.middleware(endpoint, handler)
+			}
+			mux.Handle(strings.TrimSpace(method+" "+prefix), mountAt(prefix, route, handler))
+		}
+
+		// the handlers are mounted per method, mux answers other methods
+		// with a 405 listing the mounted ones in the Allow header, a get
+		// pattern also matches head requests
+		mount(http.MethodGet, name, handlers[name])
+		if upload, ok := uploads[name]; ok && o.uploads {
+			mount(http.MethodPut, name+"_upload", upload)
+		}
+		if name == "file" && h.allowDelete {
+			mount(http.MethodDelete, "file_delete", http.HandlerFunc(h.DeleteFile))
+		}
+	}
+}
+
+func checkEndpoint(name string) {
+	for _, endpoint := range endpoints {
+		if name == endpoint {
+			return
+		}
+	}
+	panic(fmt.Sprintf("s3fileserver: unknown endpoint %q", name))
+}
+
+// mountAt serves a handler written for route under prefix by rewriting the
+// request path, so the object key is found after the custom prefix.
+func mountAt(prefix string, route string, next http.Handler) http.Handler {
+	if prefix == route {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := new(url.URL)
+		*u = *r.URL
+		u.Path = route + strings.TrimPrefix(r.URL.Path, prefix)
+		if r.URL.RawPath != "" {
+			u.RawPath = route + strings.TrimPrefix(r.URL.RawPath, prefix)
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = u
+		next.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,92 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterHandlersPrefixes(t *testing.T) {
+	plain := testPlaintext(100)
+	store := newMemStore()
+	store.put("docs/a.bin", encryptObject(t, "xor", plain), "application/octet-stream", nil)
+	h := newTestServer(t, store, nil)
+
+	var mounted []string
+	mux := http.NewServeMux()
+	h.RegisterHandlers(mux,
+		WithEndpoints("xor", "raw"),
+		WithPrefix("xor", "/files/"),
+		WithPrefix("raw", "plain"),
+		WithMiddleware(func(endpoint string, next http.Handler) http.Handler {
+			mounted = append(mounted, endpoint)
+			return next
+		}),
+	)
+	get := func(method string, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	if w := get(http.MethodGet, "/files/docs/a.bin"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+		t.Errorf("custom prefix: status %d", w.Code)
+	}
+	if w := get(http.MethodGet, "/files/docs%2Fa.bin"); w.Code != http.StatusOK {
+		t.Errorf("escaped key under a custom prefix: status %d", w.Code)
+	}
+	if w := get(http.MethodGet, "/plain/docs/a.bin"); w.Code != http.StatusOK {
+		t.Errorf("prefix without slashes: status %d", w.Code)
+	}
+	for _, target := range []string{"/xor/docs/a.bin", "/ctr/docs/a.bin"} {
+		if w := get(http.MethodGet, target); w.Code != http.StatusNotFound {
+			t.Errorf("%s not mounted: status %d", target, w.Code)
+		}
+	}
+
+	// other methods get a 405 listing the mounted ones
+	w := get(http.MethodPut, "/files/docs/a.bin")
+	if w.Code != http.StatusMethodNotAllowed || !strings.Contains(w.Header().Get("Allow"), http.MethodGet) {
+		t.Errorf("put without uploads: status %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	if strings.Join(mounted, ",") != "xor,raw" {
+		t.Errorf("middleware wrapped %v", mounted)
+	}
+}
+
+func TestRegisterHandlersOptionalMethods(t *testing.T) {
+	store := newMemStore()
+	store.put("a.bin", []byte("data"), "application/octet-stream", nil)
+
+	for _, allowDelete := range []bool{false, true} {
+		h := newTestServer(t, store, func(opts *serverOptions) {
+			opts.allowDelete = allowDelete
+			opts.chachaKeys = newKeyring("chacha", defaultKeyID, map[string][]byte{})
+		})
+		mux := http.NewServeMux()
+		h.RegisterHandlers(mux, WithUploads(true))
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/file/missing.bin", nil))
+		if allowDelete && w.Code != http.StatusNotFound || !allowDelete && w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("allow delete %v: status %d", allowDelete, w.Code)
+		}
+
+		// no chacha key, no chacha endpoint
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/chacha/a.bin", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("chacha without a key: status %d", w.Code)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("unknown endpoint accepted")
+		}
+	}()
+	newTestServer(t, store, nil).RegisterHandlers(http.NewServeMux(), WithPrefix("nope", "/nope/"))
+}
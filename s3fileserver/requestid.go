@@ -0,0 +1,72 @@
+package s3fileserver
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+// maxRequestIDLength bounds an incoming X-Request-ID, longer or unprintable
+// ids are replaced by a generated one.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// requestIDs tags every request with an id, taken from the X-Request-ID
+// header or made by generate, and echoes it in the response.
+type requestIDs struct {
+	generate func() string
+}
+
+// newRequestIDs uses newUUID when generate is nil.
+func newRequestIDs(generate func() string) requestIDs {
+	if generate == nil {
+		generate = newUUID
+	}
+
+	return requestIDs{generate: generate}
+}
+
+func (ids requestIDs) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = ids.generate()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the id of the request ctx belongs to, or an empty string.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newUUID returns a random version 4 uuid.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
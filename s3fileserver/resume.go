@@ -0,0 +1,113 @@
+package s3fileserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// resumeBackoff is the wait before the first retry of a dropped s3 body, it
+// doubles with every further retry.
+const resumeBackoff = 100 * time.Millisecond
+
+// resumableReader reads an s3 body, reopening it from the current offset
+// after a dropped connection. Bytes already read are never read twice, so
+// the readers on top, like the xor and ctr decrypters, see one continuous
+// stream and keep their state.
+type resumableReader struct {
+	ctx context.Context
+	// open returns the rest of the object from offset on
+	open    func(offset int64) (io.ReadCloser, error)
+	offset  int64
+	retries int
+	backoff time.Duration
+
+	mu     sync.Mutex
+	body   io.ReadCloser
+	closed bool
+}
+
+// newResumableReader wraps body, which starts at offset of the object, and
+// reopens it with open up to retries times. With no retries body is returned
+// as is.
+func newResumableReader(ctx context.Context, body io.ReadCloser, offset int64, retries int, open func(offset int64) (io.ReadCloser, error)) io.ReadCloser {
+	if retries <= 0 {
+		return body
+	}
+	return &resumableReader{ctx: ctx, open: open, offset: offset, retries: retries, body: body}
+}
+
+func (r *resumableReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF || !recoverableReadError(err) {
+			return n, err
+		}
+
+		// hand out what was read, the next read resumes
+		if n > 0 {
+			return n, nil
+		}
+		if r.retries == 0 || r.ctx.Err() != nil {
+			return 0, err
+		}
+		if resumeErr := r.resume(err); resumeErr != nil {
+			return 0, resumeErr
+		}
+	}
+}
+
+// resume waits out the backoff and reopens the body at the current offset.
+func (r *resumableReader) resume(cause error) error {
+	r.retries--
+	if r.backoff == 0 {
+		r.backoff = resumeBackoff
+	} else {
+		r.backoff *= 2
+	}
+	slog.WarnContext(r.ctx, "s3 read failed, resuming", "offset", r.offset, "wait", r.backoff, "err", cause)
+
+	timer := time.NewTimer(r.backoff)
+	defer timer.Stop()
+	select {
+	case <-r.ctx.Done():
+		return cause
+	case <-timer.C:
+	}
+
+	body, err := r.open(r.offset)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.body.Close()
+	if r.closed {
+		body.Close()
+		return net.ErrClosed
+	}
+	r.body = body
+	return nil
+}
+
+// Close may be called while a read is blocked, to abort it.
+func (r *resumableReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return r.body.Close()
+}
+
+// recoverableReadError reports whether err looks like a dropped connection,
+// which a new request may get past, rather than a bad object.
+func recoverableReadError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.As(err, &netErr)
+}
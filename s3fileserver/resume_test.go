@@ -0,0 +1,112 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// droppingBody returns data from offset on, failing with an unexpected eof
+// after drop bytes.
+func droppingBody(data []byte, offset int64, drop int) io.ReadCloser {
+	return io.NopCloser(io.MultiReader(bytes.NewReader(data[offset:min(offset+int64(drop), int64(len(data)))]), errReader{io.ErrUnexpectedEOF}))
+}
+
+func TestResumableReader(t *testing.T) {
+	data := testPlaintext(1000)
+
+	var opened []int64
+	open := func(offset int64) (io.ReadCloser, error) {
+		opened = append(opened, offset)
+		if len(opened) == 1 {
+			return droppingBody(data, offset, 300), nil
+		}
+		return io.NopCloser(bytes.NewReader(data[offset:])), nil
+	}
+
+	reader := newResumableReader(context.Background(), droppingBody(data, 100, 200), 100, 3, open)
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[100:]) {
+		t.Error("resumed body differs from the object")
+	}
+	if len(opened) != 2 || opened[0] != 300 || opened[1] != 600 {
+		t.Errorf("reopened at %v, want [300 600]", opened)
+	}
+}
+
+func TestResumableReaderGivesUp(t *testing.T) {
+	data := testPlaintext(1000)
+	open := func(offset int64) (io.ReadCloser, error) {
+		return droppingBody(data, offset, 10), nil
+	}
+
+	reader := newResumableReader(context.Background(), droppingBody(data, 0, 10), 0, 2, open)
+	got, err := io.ReadAll(reader)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("err %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if !bytes.Equal(got, data[:30]) {
+		t.Errorf("read %d bytes before giving up, want 30", len(got))
+	}
+
+	// an error that is not a dropped connection is not retried
+	reader = newResumableReader(context.Background(), io.NopCloser(errReader{errGCMAuth}), 0, 2, open)
+	if _, err := io.ReadAll(reader); err != errGCMAuth {
+		t.Errorf("err %v, want %v", err, errGCMAuth)
+	}
+}
+
+// TestServeResumesDroppedBody drops the s3 body of a download twice, the
+// client still gets the whole decrypted file.
+func TestServeResumesDroppedBody(t *testing.T) {
+	plain := testPlaintext(100 << 10)
+	store := newMemStore()
+	store.put("a.bin", encryptObject(t, "ctr", plain), "application/octet-stream", nil)
+	store.drops = []int{5000, 70000}
+	h := newTestServer(t, store, func(opts *serverOptions) { opts.readRetries = 3 })
+
+	w := serve(h, http.MethodGet, "/ctr/a.bin", nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+		t.Fatalf("status %d, %d of %d bytes, body matches %v", w.Code, w.Body.Len(), len(plain), bytes.Equal(w.Body.Bytes(), plain))
+	}
+	if got := store.gets; len(got) != 3 || got[1] != "bytes=5000-102415" || got[2] != "bytes=75000-102415" {
+		t.Errorf("gets %v", got)
+	}
+}
+
+// TestServeResumesAtRangeEnd drops the s3 body right after its last byte.
+// The streamed hmac check reads on to the end of the body, there is nothing
+// left to get and the download completes.
+func TestServeResumesAtRangeEnd(t *testing.T) {
+	plain := testPlaintext(10 << 10)
+	stored := encryptObject(t, "xor", plain)
+	store := newMemStore()
+	store.put("a.bin", stored, "application/octet-stream", map[string]string{"hmac": testHMAC(plain)})
+	h := newTestServer(t, store, func(opts *serverOptions) {
+		opts.readRetries = 3
+		opts.hmacKey = testHMACKey
+	})
+
+	for _, header := range [][]string{nil, {"Range", "bytes=100-199"}} {
+		before := store.getCount()
+		want := plain
+		if header != nil {
+			want = plain[100:200]
+		}
+		store.drops = []int{len(want)}
+
+		w := serve(h, http.MethodGet, "/xor/a.bin", nil, header...)
+		if !bytes.Equal(w.Body.Bytes(), want) {
+			t.Fatalf("range %v: status %d, %d of %d bytes", header, w.Code, w.Body.Len(), len(want))
+		}
+		if got := store.getCount() - before; got != 1 {
+			t.Errorf("range %v: %d gets, want 1", header, got)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package s3fileserver
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Run configures the server from the environment and an optional .env file
+// in the working directory, then serves until SIGINT or SIGTERM. It is all
+// the s3-file-server command does. With the -check flag or STARTUP_CHECK=1
+// it only validates the configuration and the s3 access and exits.
+func Run() {
+	check := flag.Bool("check", false, "validate the configuration and s3 access, then exit")
+	flag.Parse()
+
+	// load .env file, without one everything comes from the environment
+	envErr := godotenv.Load()
+	if envErr != nil && !errors.Is(envErr, fs.ErrNotExist) {
+		log.Fatalf("failed to load .env file, err: %v", envErr)
+	}
+
+	// the whole configuration is checked at once
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	// log as json, the standard logger goes through the same handler
+	slog.SetDefault(newLogger(cfg.LogLevel))
+	if envErr != nil {
+		slog.Warn("no .env file, using the environment only")
+	}
+
+	// connect to s3
+	s3Client, err := NewS3Client(cfg.AWSAccessKey, cfg.AWSAccessSecret, cfg.AWSRegion, cfg.S3Accelerate, cfg.S3Endpoint, cfg.S3PathStyle, cfg.S3Timeout)
+	if err != nil {
+		log.Fatalf("failed to init s3 client, err: %v", err)
+	}
+
+	// objects stored with sse-c need the customer key on every read and write
+	s3Client.SSECustomerKey = cfg.SSECustomerKey
+
+	// the xor and aes keys may be kept in secrets manager or ssm instead of
+	// the environment, read with the same aws config as s3
+	var secrets secretSource
+	if cfg.XORKeySecretARN != "" || cfg.AESKeySecretARN != "" {
+		awsConfig, err := loadAWSConfig(staticCredentials(cfg.AWSAccessKey, cfg.AWSAccessSecret), cfg.AWSRegion)
+		if err != nil {
+			log.Fatalf("failed to init secrets client, err: %v", err)
+		}
+		secrets = newAWSSecrets(awsConfig)
+	}
+	keys := newKeySource(secrets, cfg)
+	xorKeys, aesKeys, err := keys.load(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// each scheme has a keyring, objects select a key by their key-id tag
+	xorKeyring := newKeyring("xor", cfg.XORDefaultKeyID, xorKeys)
+	aesKeyring := newKeyring("aes", defaultKeyID, aesKeys)
+
+	// create file handler
+	fileServer := newHTTPFileServer(s3Client, cfg.serverOptions(xorKeyring, aesKeyring))
+
+	// a check run reports on the configuration without listening
+	if *check || cfg.StartupCheck {
+		if !fileServer.startupCheck(context.Background(), os.Stdout) {
+			fmt.Println("startup check failed")
+			os.Exit(1)
+		}
+		fmt.Println("startup check passed")
+		return
+	}
+
+	// start file server
+	limiter := newConcurrencyLimiter(cfg.MaxConcurrent)
+	throttle := newThrottle(cfg.MaxBytesPerSec)
+	rateLimit := newRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst, cfg.RateLimitBytesPerSec)
+	compress := newCompressor(cfg.CompressibleTypes)
+	fileRoute := func(endpoint string, handler http.Handler) http.Handler {
+		return instrumentHandler(endpoint, rateLimit.middleware(throttle.middleware(limiter.middleware(compress.middleware(cacheableResponses(cfg.CachePartial, handler))))))
+	}
+	mux := http.NewServeMux()
+	fileServer.RegisterHandlers(mux, WithUploads(cfg.AllowUploads), WithMiddleware(fileRoute))
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", ServeLiveness)
+	mux.HandleFunc("/readyz", fileServer.ServeReadiness)
+
+	// stop the server on interrupt and let in-flight downloads finish
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// keys read from secrets are reloaded to pick up rotations
+	if keys.fromSecrets() && cfg.KeySecretRefresh > 0 {
+		go keys.refresh(ctx, cfg.KeySecretRefresh, xorKeyring, aesKeyring)
+	}
+
+	// tracing is a no-op unless an otlp endpoint is configured
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		log.Fatalf("failed to set up tracing, err: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// serve https when a certificate is configured
+	tlsConfig, err := loadTLSConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCertBase64, cfg.TLSKeyBase64)
+	if err != nil {
+		log.Fatalf("failed to load tls certificate, err: %v", err)
+	}
+
+	// require an api key or a signed url for everything but the exempt paths
+	auth := newAuthenticator(cfg.APIKeys, cfg.AuthExemptPaths, cfg.URLSigningKey, cfg.URLSigningSkew)
+
+	// browsers on other origins need cors headers to read responses
+	cors := newCORSPolicy(cfg.AllowedOrigins)
+
+	// slow clients may not hold a connection open forever while sending
+	// headers or idling, the write timeout is off unless configured since it
+	// would cut large downloads short
+	server := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           newRequestIDs(nil).middleware(cfg.clientIPs.middleware(traceRequests(logRequests(cors.middleware(auth.middleware(mux)))))),
+		TLSConfig:         tlsConfig,
+		Protocols:         serverProtocols(cfg.H2C),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.HandlerTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	if err := runServer(ctx, server, cfg.ShutdownTimeout); err != nil {
+		log.Fatalf("failed to start file server, err: %v", err)
+	}
+}
@@ -0,0 +1,450 @@
+package s3fileserver
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ObjectStore is the storage the file server reads from and writes to.
+// S3Client implements it against aws s3.
+type ObjectStore interface {
+	HeadObject(ctx context.Context, bucket string, objectKey string, versionID string) (*s3.HeadObjectOutput, error)
+	GetRangeObject(ctx context.Context, bucket string, objectKey string, versionID string, ifMatch string, requestedRange string) (*s3.GetObjectOutput, error)
+	GetObjectTagging(ctx context.Context, bucket string, objectKey string, versionID string) (map[string]string, error)
+	PutObjectTagging(ctx context.Context, bucket string, objectKey string, versionID string, tags map[string]string) error
+	PutObject(ctx context.Context, bucket string, objectKey string, body io.Reader, contentType string) (*manager.UploadOutput, error)
+	DeleteObject(ctx context.Context, bucket string, objectKey string) error
+	PresignGetObject(ctx context.Context, bucket string, objectKey string, versionID string, expiry time.Duration) (string, error)
+	ListObjects(ctx context.Context, bucket string, prefix string, continuationToken string, maxKeys int32) (*s3.ListObjectsV2Output, error)
+	Ping(ctx context.Context, bucket string) error
+}
+
+var _ ObjectStore = S3Client{}
+
+// S3Client is not tied to a bucket, every call names the bucket it uses.
+type S3Client struct {
+	Client *s3.Client
+	// Timeout bounds every s3 call, zero disables it
+	Timeout time.Duration
+	// SSECustomerKey is the 32 byte sse-c key objects are stored with, nil
+	// when s3 encrypts with its own keys or not at all
+	SSECustomerKey []byte
+}
+
+// NewS3Client connects to s3 with a static access key, or with the default
+// credential chain when both are empty.
+func NewS3Client(awsAccessKey string, awsAccessSecret string, awsRegion string, s3Accelerate bool, s3Endpoint string, s3PathStyle bool, s3Timeout time.Duration) (S3Client, error) {
+	return NewS3ClientWithCredentials(staticCredentials(awsAccessKey, awsAccessSecret), awsRegion, s3Accelerate, s3Endpoint, s3PathStyle, s3Timeout)
+}
+
+// staticCredentials returns nil when no access key is configured, so the
+// default credential chain (environment, shared config, instance role, IRSA)
+// is used instead.
+func staticCredentials(awsAccessKey string, awsAccessSecret string) aws.CredentialsProvider {
+	if awsAccessKey == "" && awsAccessSecret == "" {
+		return nil
+	}
+
+	return aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(awsAccessKey, awsAccessSecret, ""))
+}
+
+// NewS3ClientWithCredentials connects to s3 with any credentials provider.
+// It fails when the aws config cannot be loaded or the options conflict.
+func NewS3ClientWithCredentials(credential aws.CredentialsProvider, awsRegion string, s3Accelerate bool, s3Endpoint string, s3PathStyle bool, s3Timeout time.Duration) (S3Client, error) {
+	// transfer acceleration only exists on aws s3 itself
+	if s3Accelerate && s3Endpoint != "" {
+		return S3Client{}, errors.New("s3 accelerate and a custom s3 endpoint cannot be used together")
+	}
+
+	// load s3 config
+	cfg, err := loadAWSConfig(credential, awsRegion)
+	if err != nil {
+		return S3Client{}, fmt.Errorf("failed to load aws config, err: %w", err)
+	}
+
+	// create s3 client
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UseAccelerate = s3Accelerate
+		o.UsePathStyle = s3PathStyle
+		if s3Endpoint != "" {
+			o.BaseEndpoint = aws.String(s3Endpoint)
+		}
+	})
+
+	return S3Client{Client: client, Timeout: s3Timeout}, nil
+}
+
+// loadAWSConfig loads the aws config shared by every aws client, a nil
+// credential uses the default credential chain.
+func loadAWSConfig(credential aws.CredentialsProvider, awsRegion string) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{config.WithRegion(awsRegion)}
+	if credential != nil {
+		opts = append(opts, config.WithCredentialsProvider(credential))
+	}
+
+	return config.LoadDefaultConfig(context.TODO(), opts...)
+}
+
+// withTimeout derives the context of a single s3 call.
+func (s S3Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.Timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, s.Timeout)
+}
+
+// sseCustomer returns the algorithm, key and key md5 s3 calls on sse-c
+// objects need, all nil without a customer key.
+func (s S3Client) sseCustomer() (*string, *string, *string) {
+	if len(s.SSECustomerKey) == 0 {
+		return nil, nil, nil
+	}
+
+	sum := md5.Sum(s.SSECustomerKey)
+	return aws.String("AES256"), aws.String(base64.StdEncoding.EncodeToString(s.SSECustomerKey)), aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// versionID selects a version of the object in a versioned bucket, an empty
+// versionID is the current version.
+func (s S3Client) HeadObject(ctx context.Context, bucket string, objectKey string, versionID string) (*s3.HeadObjectOutput, error) {
+	objInput := &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectKey),
+	}
+	if versionID != "" {
+		objInput.VersionId = aws.String(versionID)
+	}
+	objInput.SSECustomerAlgorithm, objInput.SSECustomerKey, objInput.SSECustomerKeyMD5 = s.sseCustomer()
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	ctx, span := tracer().Start(ctx, "s3.HeadObject", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("bucket", bucket),
+		attribute.String("object_key", objectKey),
+	))
+
+	start := time.Now()
+	out, err := s.Client.HeadObject(ctx, objInput)
+	observeS3Request("HeadObject", start, err)
+	logS3Error(ctx, "HeadObject", objectKey, err)
+	endSpan(span, err)
+
+	return out, err
+}
+
+// ifMatch, when set, fails the call with PreconditionFailed unless the
+// object still has that etag.
+func (s S3Client) GetRangeObject(ctx context.Context, bucket string, objectKey string, versionID string, ifMatch string, requestedRange string) (*s3.GetObjectOutput, error) {
+	input := s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectKey),
+		Range:  aws.String(requestedRange),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	if ifMatch != "" {
+		input.IfMatch = aws.String(ifMatch)
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.sseCustomer()
+
+	// the span covers the response headers, not reading the body
+	ctx, span := tracer().Start(ctx, "s3.GetObject", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("bucket", bucket),
+		attribute.String("object_key", objectKey),
+		attribute.String("range", requestedRange),
+	))
+
+	if s.Timeout <= 0 {
+		start := time.Now()
+		out, err := s.Client.GetObject(ctx, &input)
+		observeS3Request("GetObject", start, err)
+		logS3Error(ctx, "GetObject", objectKey, err)
+		endSpan(span, err)
+		return out, err
+	}
+
+	// the timeout only covers the response headers, streaming the body of a
+	// large object can legitimately take much longer
+	ctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(s.Timeout, cancel)
+
+	start := time.Now()
+	out, err := s.Client.GetObject(ctx, &input)
+	timedOut := !timer.Stop()
+	if err != nil {
+		cancel()
+		if timedOut {
+			err = fmt.Errorf("get object timed out after %s: %w", s.Timeout, context.DeadlineExceeded)
+		}
+		observeS3Request("GetObject", start, err)
+		logS3Error(ctx, "GetObject", objectKey, err)
+		endSpan(span, err)
+		return nil, err
+	}
+	observeS3Request("GetObject", start, err)
+	endSpan(span, nil)
+
+	// release the context once the body has been read
+	out.Body = cancelOnClose{out.Body, cancel}
+	return out, nil
+}
+
+// cancelOnClose cancels the context of a get object call when its body is
+// closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// GetObjectTagging returns the tags of an object version as a map.
+func (s S3Client) GetObjectTagging(ctx context.Context, bucket string, objectKey string, versionID string) (map[string]string, error) {
+	input := s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectKey),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	ctx, span := tracer().Start(ctx, "s3.GetObjectTagging", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("bucket", bucket),
+		attribute.String("object_key", objectKey),
+	))
+
+	start := time.Now()
+	tags, err := s.Client.GetObjectTagging(ctx, &input)
+	observeS3Request("GetObjectTagging", start, err)
+	logS3Error(ctx, "GetObjectTagging", objectKey, err)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string)
+	for _, tag := range tags.TagSet {
+		out[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	return out, nil
+}
+
+// PutObjectTagging replaces the tags of an object version with tags. Tags
+// over the s3 limits are rejected with ErrTooManyTags, ErrEmptyTagKey,
+// ErrTagKeyTooLong or ErrTagValueTooLong without calling s3. The tags are
+// sent in the xml request body, so values need no escaping.
+func (s S3Client) PutObjectTagging(ctx context.Context, bucket string, objectKey string, versionID string, tags map[string]string) error {
+	if err := validateTags(tags); err != nil {
+		return err
+	}
+
+	input := s3.PutObjectTaggingInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(objectKey),
+		Tagging: &types.Tagging{},
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	for key, value := range tags {
+		input.Tagging.TagSet = append(input.Tagging.TagSet, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	ctx, span := tracer().Start(ctx, "s3.PutObjectTagging", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("bucket", bucket),
+		attribute.String("object_key", objectKey),
+	))
+
+	start := time.Now()
+	_, err := s.Client.PutObjectTagging(ctx, &input)
+	observeS3Request("PutObjectTagging", start, err)
+	logS3Error(ctx, "PutObjectTagging", objectKey, err)
+	endSpan(span, err)
+
+	return err
+}
+
+// PutObject uploads body, in parts when it is large.
+func (s S3Client) PutObject(ctx context.Context, bucket string, objectKey string, body io.Reader, contentType string) (*manager.UploadOutput, error) {
+	input := s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectKey),
+		Body:   body,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.sseCustomer()
+
+	// the uploader switches to a multipart upload for large bodies. no
+	// timeout is applied since the upload runs as long as the client body
+	ctx, span := tracer().Start(ctx, "s3.PutObject", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("bucket", bucket),
+		attribute.String("object_key", objectKey),
+	))
+
+	start := time.Now()
+	out, err := manager.NewUploader(s.Client).Upload(ctx, &input)
+	observeS3Request("PutObject", start, err)
+	logS3Error(ctx, "PutObject", objectKey, err)
+	endSpan(span, err)
+
+	return out, err
+}
+
+// DeleteObject deletes the current version of an object.
+func (s S3Client) DeleteObject(ctx context.Context, bucket string, objectKey string) error {
+	input := s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectKey),
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	ctx, span := tracer().Start(ctx, "s3.DeleteObject", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("bucket", bucket),
+		attribute.String("object_key", objectKey),
+	))
+
+	start := time.Now()
+	_, err := s.Client.DeleteObject(ctx, &input)
+	observeS3Request("DeleteObject", start, err)
+	logS3Error(ctx, "DeleteObject", objectKey, err)
+	endSpan(span, err)
+
+	return err
+}
+
+// ErrPresignSSEC is returned by PresignGetObject with an sse-c key, a url
+// downloading such an object would only work with the key sent alongside it.
+var ErrPresignSSEC = errors.New("presigned urls are not available for objects stored with sse-c")
+
+// PresignGetObject returns a url that downloads the object straight from s3
+// until expiry has passed. It fails with ErrPresignSSEC when objects are
+// stored with an sse-c key.
+func (s S3Client) PresignGetObject(ctx context.Context, bucket string, objectKey string, versionID string, expiry time.Duration) (string, error) {
+	if len(s.SSECustomerKey) != 0 {
+		return "", ErrPresignSSEC
+	}
+
+	input := s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(objectKey),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	// presigning is done locally, it never calls s3
+	ctx, span := tracer().Start(ctx, "s3.PresignGetObject", trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(
+		attribute.String("bucket", bucket),
+		attribute.String("object_key", objectKey),
+	))
+	req, err := s3.NewPresignClient(s.Client).PresignGetObject(ctx, &input, s3.WithPresignExpires(expiry))
+	endSpan(span, err)
+	if err != nil {
+		return "", err
+	}
+
+	return req.URL, nil
+}
+
+// ListObjects lists up to maxKeys objects under prefix, continuing after
+// continuationToken when it is set.
+func (s S3Client) ListObjects(ctx context.Context, bucket string, prefix string, continuationToken string, maxKeys int32) (*s3.ListObjectsV2Output, error) {
+	input := s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		MaxKeys: aws.Int32(maxKeys),
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	if continuationToken != "" {
+		input.ContinuationToken = aws.String(continuationToken)
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	ctx, span := tracer().Start(ctx, "s3.ListObjectsV2", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("bucket", bucket),
+		attribute.String("prefix", prefix),
+	))
+
+	start := time.Now()
+	out, err := s.Client.ListObjectsV2(ctx, &input)
+	observeS3Request("ListObjectsV2", start, err)
+	logS3Error(ctx, "ListObjectsV2", prefix, err)
+	endSpan(span, err)
+
+	return out, err
+}
+
+// Ping checks that the bucket is reachable with the configured credentials.
+func (s S3Client) Ping(ctx context.Context, bucket string) error {
+	input := s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	ctx, span := tracer().Start(ctx, "s3.HeadBucket", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("bucket", bucket),
+	))
+
+	start := time.Now()
+	_, err := s.Client.HeadBucket(ctx, &input)
+	observeS3Request("HeadBucket", start, err)
+	logS3Error(ctx, "HeadBucket", "", err)
+	endSpan(span, err)
+
+	return err
+}
+
+// logS3Error logs a failed s3 call with the request id of ctx and the ids s3
+// assigned to the call. Missing objects and cancelled requests are expected
+// and only logged at debug level.
+func logS3Error(ctx context.Context, operation string, objectKey string, err error) {
+	if err == nil {
+		return
+	}
+
+	level := slog.LevelError
+	if mapS3Error(err) == http.StatusNotFound || errors.Is(err, context.Canceled) {
+		level = slog.LevelDebug
+	}
+	reqID, extID := s3RequestIDs(err)
+	slog.Log(ctx, level, "s3 request failed", "operation", operation, "object_key", objectKey, "s3_request_id", reqID, "s3_extended_request_id", extID, "err", err)
+}
@@ -0,0 +1,117 @@
+package s3fileserver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTestS3Client returns an S3Client sending its requests to handler.
+func newTestS3Client(t *testing.T, handler http.HandlerFunc) S3Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return S3Client{Client: s3.New(s3.Options{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		BaseEndpoint:     aws.String(srv.URL),
+		UsePathStyle:     true,
+		RetryMaxAttempts: 1,
+	})}
+}
+
+// TestNewS3ClientErrors returns the errors of a client that cannot be set
+// up, leaving it to the caller to exit.
+func TestNewS3ClientErrors(t *testing.T) {
+	if _, err := NewS3Client("key", "secret", "us-east-1", true, "http://localhost:9000", false, 0); err == nil {
+		t.Error("accelerate with a custom endpoint accepted")
+	}
+
+	client, err := NewS3Client("key", "secret", "us-east-1", false, "http://localhost:9000", true, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Client == nil || client.Timeout != time.Second {
+		t.Errorf("client %+v", client)
+	}
+}
+
+// TestS3ClientSpans records a child span for every s3 call, presigning
+// included.
+func TestS3ClientSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	client := newTestS3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	})
+	ctx := context.Background()
+	client.HeadObject(ctx, "bucket", "a.bin", "")
+	client.GetRangeObject(ctx, "bucket", "a.bin", "", "", "bytes=0-9")
+	client.GetObjectTagging(ctx, "bucket", "a.bin", "")
+	client.PutObjectTagging(ctx, "bucket", "a.bin", "", map[string]string{"k": "v"})
+	client.PutObject(ctx, "bucket", "a.bin", strings.NewReader("data"), "")
+	client.DeleteObject(ctx, "bucket", "a.bin")
+	client.ListObjects(ctx, "bucket", "docs/", "", 10)
+	client.PresignGetObject(ctx, "bucket", "a.bin", "", time.Minute)
+	client.Ping(ctx, "bucket")
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+	}
+	want := []string{"s3.HeadObject", "s3.GetObject", "s3.GetObjectTagging", "s3.PutObjectTagging", "s3.PutObject", "s3.DeleteObject", "s3.ListObjectsV2", "s3.PresignGetObject", "s3.HeadBucket"}
+	if !slices.Equal(names, want) {
+		t.Errorf("spans %v, want %v", names, want)
+	}
+}
+
+// TestPresignSSEC refuses to presign with an sse-c key, the url would not
+// work without it.
+func TestPresignSSEC(t *testing.T) {
+	client := newTestS3Client(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	url, err := client.PresignGetObject(context.Background(), "bucket", "a.bin", "", time.Minute)
+	if err != nil || !strings.Contains(url, "/bucket/a.bin?") {
+		t.Fatalf("url %q, %v", url, err)
+	}
+
+	client.SSECustomerKey = testChaChaKey
+	if _, err := client.PresignGetObject(context.Background(), "bucket", "a.bin", "", time.Minute); err != ErrPresignSSEC {
+		t.Errorf("sse-c: err %v, want %v", err, ErrPresignSSEC)
+	}
+}
+
+// ssecStore presigns like an S3Client with an sse-c key.
+type ssecStore struct {
+	*memStore
+}
+
+func (s ssecStore) PresignGetObject(ctx context.Context, bucket string, objectKey string, versionID string, expiry time.Duration) (string, error) {
+	return "", ErrPresignSSEC
+}
+
+func TestServePresignSSEC(t *testing.T) {
+	store := newMemStore()
+	store.put("a.bin", []byte("plain"), "application/octet-stream", nil)
+	h := newTestServer(t, ssecStore{store}, nil)
+
+	if w := serve(h, http.MethodGet, "/presign/a.bin", nil); w.Code != http.StatusConflict {
+		t.Errorf("status %d, want 409", w.Code)
+	}
+}
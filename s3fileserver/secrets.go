@@ -0,0 +1,145 @@
+package s3fileserver
+
+import (
+	"context"
+	"crypto/cipher"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// secretSource returns the value of a secret by its id.
+type secretSource interface {
+	GetSecret(ctx context.Context, id string) (string, error)
+}
+
+// awsSecrets reads secrets from aws secrets manager, or from ssm parameter
+// store when the id is the arn of a parameter.
+type awsSecrets struct {
+	secretsManager *secretsmanager.Client
+	parameters     *ssm.Client
+}
+
+func newAWSSecrets(cfg aws.Config) awsSecrets {
+	return awsSecrets{
+		secretsManager: secretsmanager.NewFromConfig(cfg),
+		parameters:     ssm.NewFromConfig(cfg),
+	}
+}
+
+func (a awsSecrets) GetSecret(ctx context.Context, id string) (string, error) {
+	if isParameterARN(id) {
+		out, err := a.parameters.GetParameter(ctx, &ssm.GetParameterInput{
+			Name:           aws.String(id),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(out.Parameter.Value), nil
+	}
+
+	out, err := a.secretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// binary secrets hold the key itself
+	if out.SecretString == nil {
+		return string(out.SecretBinary), nil
+	}
+	return aws.ToString(out.SecretString), nil
+}
+
+// isParameterARN reports whether id is an ssm arn, like
+// arn:aws:ssm:region:account:parameter/name.
+func isParameterARN(id string) bool {
+	parts := strings.SplitN(id, ":", 4)
+	return len(parts) == 4 && parts[0] == "arn" && parts[2] == "ssm"
+}
+
+// keySource loads the xor and aes keys of a config, reading XOR_KEY and
+// AES_KEY from secrets manager or ssm when their secret arn is set.
+type keySource struct {
+	secrets secretSource
+	config  Config
+}
+
+func newKeySource(secrets secretSource, config Config) keySource {
+	return keySource{secrets: secrets, config: config}
+}
+
+// fromSecrets reports whether any key is read from a secret.
+func (s keySource) fromSecrets() bool {
+	return s.config.XORKeySecretARN != "" || s.config.AESKeySecretARN != ""
+}
+
+// value returns the secret behind arn, or the configured value when arn is
+// empty.
+func (s keySource) value(ctx context.Context, name string, value string, arn string) (string, error) {
+	if arn == "" {
+		return value, nil
+	}
+
+	value, err := s.secrets.GetSecret(ctx, arn)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from secret %q, err: %w", name, arn, err)
+	}
+	return value, nil
+}
+
+// load returns the xor and aes keys by id, see loadXORKeys and loadAESKeys.
+func (s keySource) load(ctx context.Context) (map[string][]byte, map[string]cipher.Block, error) {
+	xorKey, err := s.value(ctx, "XOR_KEY", s.config.XORKey, s.config.XORKeySecretARN)
+	if err != nil {
+		return nil, nil, err
+	}
+	xorKeys, err := loadXORKeys(xorKey, s.config.XORKeys, s.config.XORKeyEncoding, s.config.XORDefaultKeyID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load xor keys, err: %w", err)
+	}
+
+	aesKey, err := s.value(ctx, "AES_KEY", s.config.AESKey, s.config.AESKeySecretARN)
+	if err != nil {
+		return nil, nil, err
+	}
+	aesKeys, err := loadAESKeys(aesKey, s.config.AESKeyEncoding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return xorKeys, aesKeys, nil
+}
+
+// refresh reloads the keys into the keyrings every interval until ctx is
+// done, so rotated secrets are picked up without a restart. A failed reload
+// is logged and the current keys are kept.
+func (s keySource) refresh(ctx context.Context, interval time.Duration, xorKeys keyring[[]byte], aesKeys keyring[cipher.Block]) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		xor, aes, err := s.load(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to refresh keys", "err", err)
+			continue
+		}
+
+		xorKeys.replace(xor)
+		aesKeys.replace(aes)
+		slog.InfoContext(ctx, "refreshed keys")
+	}
+}
@@ -0,0 +1,201 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+const (
+	testSecretARN    = "arn:aws:secretsmanager:us-east-1:123456789012:secret:xor-key-AbCdEf"
+	testParameterARN = "arn:aws:ssm:us-east-1:123456789012:parameter/aes-key"
+)
+
+// fakeSecrets is a secretSource of fixed values, a secret missing from it
+// fails with err.
+type fakeSecrets struct {
+	mu     sync.Mutex
+	values map[string]string
+	err    error
+}
+
+var _ secretSource = (*fakeSecrets)(nil)
+
+func (f *fakeSecrets) set(id string, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[id] = value
+}
+
+func (f *fakeSecrets) GetSecret(ctx context.Context, id string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, ok := f.values[id]
+	if !ok {
+		return "", f.err
+	}
+	return value, nil
+}
+
+func TestIsParameterARN(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{testParameterARN, true},
+		{"arn:aws-cn:ssm:cn-north-1:123456789012:parameter/a/b", true},
+		{testSecretARN, false},
+		{"aes-key", false},
+		{"/aes-key", false},
+		{"arn:aws:ssm", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isParameterARN(tt.id); got != tt.want {
+			t.Errorf("isParameterARN(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+// TestAWSSecrets reads a secrets manager secret and an ssm parameter from a
+// fake aws endpoint, each arn going to its service.
+func TestAWSSecrets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]any
+		json.NewDecoder(r.Body).Decode(&input)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "secretsmanager.GetSecretValue":
+			switch input["SecretId"] {
+			case testSecretARN:
+				json.NewEncoder(w).Encode(map[string]any{"SecretString": "string secret"})
+			case "binary":
+				json.NewEncoder(w).Encode(map[string]any{"SecretBinary": []byte("binary secret")})
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]any{"__type": "ResourceNotFoundException"})
+			}
+		case "AmazonSSM.GetParameter":
+			if input["Name"] != testParameterARN || input["WithDecryption"] != true {
+				t.Errorf("get parameter %v", input)
+			}
+			json.NewEncoder(w).Encode(map[string]any{"Parameter": map[string]any{"Value": "parameter"}})
+		default:
+			t.Errorf("unexpected call %q", r.Header.Get("X-Amz-Target"))
+		}
+	}))
+	defer srv.Close()
+
+	cfg := aws.Config{
+		Region:           "us-east-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		BaseEndpoint:     aws.String(srv.URL),
+		RetryMaxAttempts: 1,
+	}
+	secrets := awsSecrets{secretsManager: secretsmanager.NewFromConfig(cfg), parameters: ssm.NewFromConfig(cfg)}
+
+	for id, want := range map[string]string{testSecretARN: "string secret", "binary": "binary secret", testParameterARN: "parameter"} {
+		if got, err := secrets.GetSecret(context.Background(), id); err != nil || got != want {
+			t.Errorf("GetSecret(%q) = %q, %v, want %q", id, got, err, want)
+		}
+	}
+	if _, err := secrets.GetSecret(context.Background(), "missing"); err == nil {
+		t.Error("missing secret read")
+	}
+}
+
+func TestKeySourceLoad(t *testing.T) {
+	config := Config{XORKeySecretARN: testSecretARN, AESKeySecretARN: testParameterARN, XORDefaultKeyID: defaultKeyID}
+	secrets := &fakeSecrets{values: map[string]string{
+		testSecretARN:    "secretkey",
+		testParameterARN: "0123456789abcdef0123456789abcdef",
+	}, err: errors.New("access denied")}
+
+	keys := newKeySource(secrets, config)
+	if !keys.fromSecrets() {
+		t.Error("keys not read from secrets")
+	}
+	xorKeys, aesKeys, err := keys.load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(xorKeys[defaultKeyID], []byte("secretkey")) || aesKeys[defaultKeyID] == nil {
+		t.Errorf("loaded xor keys %q and aes keys %v", xorKeys, aesKeys)
+	}
+
+	// without an arn the configured key is used
+	if newKeySource(nil, Config{XORKey: "envkey", XORDefaultKeyID: defaultKeyID}).fromSecrets() {
+		t.Error("configured keys reported as read from secrets")
+	}
+
+	// a secret that cannot be read fails the startup naming it
+	config.AESKeySecretARN = "arn:aws:ssm:us-east-1:123456789012:parameter/missing"
+	_, _, err = newKeySource(secrets, config).load(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "AES_KEY") || !strings.Contains(err.Error(), "parameter/missing") || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("load error %v", err)
+	}
+}
+
+// TestKeySourceRefresh rotates the secrets behind running keyrings, a failed
+// reload keeps the keys and the next one swaps them.
+func TestKeySourceRefresh(t *testing.T) {
+	config := Config{XORKeySecretARN: testSecretARN, XORDefaultKeyID: defaultKeyID}
+	secrets := &fakeSecrets{values: map[string]string{testSecretARN: "oldkey"}, err: errors.New("throttled")}
+	keys := newKeySource(secrets, config)
+
+	xor, aes, err := keys.load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	xorKeys := newKeyring("xor", defaultKeyID, xor)
+	aesKeys := newKeyring("aes", defaultKeyID, aes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		keys.refresh(ctx, time.Millisecond, xorKeys, aesKeys)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	current := func() string {
+		key, _ := xorKeys.get("")
+		return string(key)
+	}
+	// a reload that fails keeps the current key
+	secrets.mu.Lock()
+	delete(secrets.values, testSecretARN)
+	secrets.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	if current() != "oldkey" {
+		t.Fatalf("xor key %q after a failed reload, want the old key", current())
+	}
+
+	// the next reload swaps in the rotated key
+	secrets.set(testSecretARN, "newkey")
+	for deadline := time.Now().Add(5 * time.Second); current() != "newkey"; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("xor key %q, want the rotated key", current())
+		}
+	}
+	if aesKeys.len() != 0 {
+		t.Errorf("%d aes keys, none are configured", aesKeys.len())
+	}
+}
@@ -0,0 +1,106 @@
+package s3fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// validateListenAddr checks that addr is a host:port pair with a numeric port.
+// The host may be empty to listen on every interface, and port 0 picks an
+// ephemeral port.
+func validateListenAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", port)
+	}
+
+	return nil
+}
+
+// serverProtocols returns the protocols to serve: http/1.1, http/2 over tls
+// negotiated with alpn and, with h2c, cleartext http/2 for clients that
+// start with the http/2 preface, like a proxy terminating tls.
+func serverProtocols(h2c bool) *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(h2c)
+	return protocols
+}
+
+// connCounter tracks the number of open connections of a server.
+type connCounter struct {
+	active atomic.Int64
+}
+
+func (c *connCounter) trackConn(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		c.active.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		c.active.Add(-1)
+	}
+}
+
+// runServer serves until ctx is canceled, then stops accepting connections
+// and waits up to shutdownTimeout for in-flight requests to finish.
+func runServer(ctx context.Context, server *http.Server, shutdownTimeout time.Duration) error {
+	conns := &connCounter{}
+	server.ConnState = conns.trackConn
+
+	// listen before serving so an ephemeral port can be logged
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+
+	serveErr := make(chan error, 1)
+	if server.TLSConfig != nil {
+		log.Printf("file server listening on %s (tls) ...\n", ln.Addr())
+		go func() {
+			serveErr <- server.ServeTLS(ln, "", "")
+		}()
+	} else {
+		log.Printf("file server listening on %s ...\n", ln.Addr())
+		go func() {
+			serveErr <- server.Serve(ln)
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	open := conns.active.Load()
+	log.Printf("shutting down file server, draining %d connections ...\n", open)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		// cut the remaining streams once the drain timeout is over
+		remaining := conns.active.Load()
+		server.Close()
+		log.Printf("drained %d connections, closed %d connections after timeout\n", open-remaining, remaining)
+		return nil
+	}
+	log.Printf("drained %d connections\n", open)
+
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
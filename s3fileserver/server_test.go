@@ -0,0 +1,167 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestH2C serves a ranged download over cleartext http/2 when h2c is on,
+// http/1.1 keeps working either way.
+func TestH2C(t *testing.T) {
+	plain := testPlaintext(1000)
+	store := newMemStore()
+	store.put("a.bin", encryptObject(t, "ctr", plain), "application/octet-stream", nil)
+	mux := http.NewServeMux()
+	newTestServer(t, store, nil).RegisterHandlers(mux)
+
+	for _, h2c := range []bool{false, true} {
+		server := httptest.NewUnstartedServer(mux)
+		server.Config.Protocols = serverProtocols(h2c)
+		server.Start()
+		defer server.Close()
+
+		// prior knowledge h2c, without an upgrade
+		h2 := new(http.Protocols)
+		h2.SetUnencryptedHTTP2(true)
+		client := &http.Client{Transport: &http.Transport{Protocols: h2}}
+
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/ctr/a.bin", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Range", "bytes=100-199")
+		resp, err := client.Do(req)
+		if !h2c {
+			if err == nil {
+				resp.Body.Close()
+				t.Error("h2c served with h2c off")
+			}
+		} else {
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil || resp.ProtoMajor != 2 || resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, plain[100:200]) {
+				t.Errorf("h2c: %s status %d, %v", resp.Proto, resp.StatusCode, err)
+			}
+		}
+
+		resp, err = http.Get(server.URL + "/ctr/a.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.ProtoMajor != 1 || resp.StatusCode != http.StatusOK {
+			t.Errorf("h2c %v: http/1.1 got %s status %d", h2c, resp.Proto, resp.StatusCode)
+		}
+	}
+}
+
+// TestLastModifiedHeadAndGet sends the same Last-Modified on head and get
+// requests, both taken from the object metadata.
+func TestLastModifiedHeadAndGet(t *testing.T) {
+	store := newMemStore()
+	store.put("a.bin", encryptObject(t, "ctr", testPlaintext(100)), "application/octet-stream", nil)
+	h := newTestServer(t, store, nil)
+
+	want := testLastModified.Format(http.TimeFormat)
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		if got := serve(h, method, "/ctr/a.bin", nil).Header().Get("Last-Modified"); got != want {
+			t.Errorf("%s: Last-Modified %q, want %q", method, got, want)
+		}
+	}
+}
+
+// testTLSConfig returns the server tls config of a self signed certificate
+// for 127.0.0.1, loaded from base64 like TLS_CERT_BASE64 and TLS_KEY_BASE64.
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cfg, err := loadTLSConfig("", "", base64.StdEncoding.EncodeToString(certPEM), base64.StdEncoding.EncodeToString(keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// TestTLSAdvertisesH2 offers h2 in alpn when tls is on and serves ranged
+// downloads over it.
+func TestTLSAdvertisesH2(t *testing.T) {
+	plain := testPlaintext(1000)
+	store := newMemStore()
+	store.put("a.bin", encryptObject(t, "ctr", plain), "application/octet-stream", nil)
+	mux := http.NewServeMux()
+	newTestServer(t, store, nil).RegisterHandlers(mux)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: mux, Protocols: serverProtocols(false), TLSConfig: testTLSConfig(t)}
+	go server.ServeTLS(ln, "", "")
+	defer server.Close()
+
+	for _, protos := range [][]string{{"h2", "http/1.1"}, {"http/1.1"}} {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: protos})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := conn.ConnectionState().NegotiatedProtocol; got != protos[0] {
+			t.Errorf("offered %v, negotiated %q", protos, got)
+		}
+		conn.Close()
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	req, err := http.NewRequest(http.MethodGet, "https://"+ln.Addr().String()+"/ctr/a.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=100-199")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.ProtoMajor != 2 || resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, plain[100:200]) {
+		t.Errorf("%s status %d, %v", resp.Proto, resp.StatusCode, err)
+	}
+}
@@ -0,0 +1,327 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+var testLastModified = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// memObject is an object of a memStore.
+type memObject struct {
+	data        []byte
+	contentType string
+	tags        map[string]string
+	etag        string
+}
+
+// memStore is an in-memory ObjectStore answering like s3 does, for the
+// handler tests.
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string]*memObject
+	// heads counts the HeadObject calls
+	heads int
+	// gets records the range header of every GetRangeObject call
+	gets []string
+	// drops cuts the body of the next gets short after that many bytes, with
+	// an unexpected eof
+	drops []int
+	// sparse leaves out the content type, etag, last modified time and the
+	// length of gets, which s3 compatible stores may not send
+	sparse bool
+}
+
+var _ ObjectStore = (*memStore)(nil)
+
+func newMemStore() *memStore {
+	return &memStore{objects: map[string]*memObject{}}
+}
+
+// put stores data under key of the test bucket.
+func (s *memStore) put(key string, data []byte, contentType string, tags map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sum := md5.Sum(data)
+	s.objects["bucket/"+key] = &memObject{
+		data:        bytes.Clone(data),
+		contentType: contentType,
+		tags:        tags,
+		etag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+	}
+}
+
+func (s *memStore) object(bucket string, key string) (*memObject, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.objects[bucket+"/"+key]
+	return obj, ok
+}
+
+// getCount returns the number of gets made so far.
+func (s *memStore) getCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.gets)
+}
+
+func (s *memStore) HeadObject(ctx context.Context, bucket string, objectKey string, versionID string) (*s3.HeadObjectOutput, error) {
+	s.mu.Lock()
+	s.heads++
+	s.mu.Unlock()
+
+	obj, ok := s.object(bucket, objectKey)
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	if s.sparse {
+		return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(obj.data)))}, nil
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(obj.data))),
+		ContentType:   aws.String(obj.contentType),
+		ETag:          aws.String(obj.etag),
+		LastModified:  aws.Time(testLastModified),
+	}, nil
+}
+
+func (s *memStore) GetRangeObject(ctx context.Context, bucket string, objectKey string, versionID string, ifMatch string, requestedRange string) (*s3.GetObjectOutput, error) {
+	s.mu.Lock()
+	s.gets = append(s.gets, requestedRange)
+	drop := -1
+	if len(s.drops) > 0 {
+		drop, s.drops = s.drops[0], s.drops[1:]
+	}
+	s.mu.Unlock()
+
+	obj, ok := s.object(bucket, objectKey)
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	if ifMatch != "" && ifMatch != obj.etag {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+
+	// s3 clamps the end and rejects a start past the object
+	size := int64(len(obj.data))
+	start, end := int64(0), size-1
+	if requestedRange != "" {
+		first, last, _ := strings.Cut(strings.TrimPrefix(requestedRange, "bytes="), "-")
+		start, _ = strconv.ParseInt(first, 10, 64)
+		if last != "" {
+			end, _ = strconv.ParseInt(last, 10, 64)
+		}
+		if start >= size || start > end {
+			return nil, &smithy.GenericAPIError{Code: "InvalidRange"}
+		}
+		end = min(end, size-1)
+	}
+
+	var body io.Reader = bytes.NewReader(obj.data[start : end+1])
+	if drop >= 0 {
+		body = io.MultiReader(io.LimitReader(body, int64(drop)), errReader{io.ErrUnexpectedEOF})
+	}
+	if s.sparse {
+		return &s3.GetObjectOutput{Body: io.NopCloser(body)}, nil
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(body),
+		ContentLength: aws.Int64(end - start + 1),
+		ETag:          aws.String(obj.etag),
+	}, nil
+}
+
+func (s *memStore) GetObjectTagging(ctx context.Context, bucket string, objectKey string, versionID string) (map[string]string, error) {
+	obj, ok := s.object(bucket, objectKey)
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return obj.tags, nil
+}
+
+func (s *memStore) PutObjectTagging(ctx context.Context, bucket string, objectKey string, versionID string, tags map[string]string) error {
+	obj, ok := s.object(bucket, objectKey)
+	if !ok {
+		return &types.NoSuchKey{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj.tags = tags
+	return nil
+}
+
+func (s *memStore) PutObject(ctx context.Context, bucket string, objectKey string, body io.Reader, contentType string) (*manager.UploadOutput, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(data)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+objectKey] = &memObject{data: data, contentType: contentType, etag: etag}
+	if s.sparse {
+		return &manager.UploadOutput{}, nil
+	}
+	return &manager.UploadOutput{ETag: aws.String(etag)}, nil
+}
+
+func (s *memStore) DeleteObject(ctx context.Context, bucket string, objectKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, bucket+"/"+objectKey)
+	return nil
+}
+
+func (s *memStore) PresignGetObject(ctx context.Context, bucket string, objectKey string, versionID string, expiry time.Duration) (string, error) {
+	return fmt.Sprintf("https://%s.s3.example.com/%s?expires=%d", bucket, objectKey, int(expiry.Seconds())), nil
+}
+
+func (s *memStore) ListObjects(ctx context.Context, bucket string, prefix string, continuationToken string, maxKeys int32) (*s3.ListObjectsV2Output, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for name := range s.objects {
+		key, ok := strings.CutPrefix(name, bucket+"/")
+		if ok && strings.HasPrefix(key, prefix) && key > continuationToken {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	out := &s3.ListObjectsV2Output{}
+	for i, key := range keys {
+		if int32(i) == maxKeys {
+			out.IsTruncated = aws.Bool(true)
+			out.NextContinuationToken = aws.String(keys[i-1])
+			break
+		}
+		obj := s.objects[bucket+"/"+key]
+		if s.sparse {
+			out.Contents = append(out.Contents, types.Object{Key: aws.String(key)})
+			continue
+		}
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(obj.data))),
+			ETag:         aws.String(obj.etag),
+			LastModified: aws.Time(testLastModified),
+		})
+	}
+	return out, nil
+}
+
+func (s *memStore) Ping(ctx context.Context, bucket string) error {
+	return nil
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+var testChaChaKey = []byte("0123456789abcdef0123456789abcdef")
+
+// newTestServer returns a server of store for the bucket named bucket, with
+// a key for every scheme. configure, when not nil, changes the options.
+func newTestServer(t testing.TB, store ObjectStore, configure func(opts *serverOptions)) HTTPFileServer {
+	t.Helper()
+	buckets, err := newBucketRouter("bucket", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := defaultServerOptions()
+	opts.buckets = buckets
+	opts.xorKeys = newKeyring("xor", defaultKeyID, map[string][]byte{defaultKeyID: []byte("secretkey")})
+	opts.aesKeys = newKeyring("aes", defaultKeyID, map[string]cipher.Block{defaultKeyID: testAESBlock(t)})
+	opts.chachaKeys = newKeyring("chacha", defaultKeyID, map[string][]byte{defaultKeyID: testChaChaKey})
+	if configure != nil {
+		configure(&opts)
+	}
+	return newHTTPFileServer(store, opts)
+}
+
+// serve sends a request to the handlers h mounts, uploads included, and
+// returns the response. header holds name and value pairs.
+func serve(h HTTPFileServer, method string, target string, body io.Reader, header ...string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	h.RegisterHandlers(mux, WithUploads(true))
+
+	r := httptest.NewRequest(method, target, body)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+// encryptObject returns plain stored as scheme does with the test keys.
+func encryptObject(t testing.TB, scheme string, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+
+	switch scheme {
+	case "none":
+		buf.Write(plain)
+	case "xor":
+		NewXorWriter(&buf, []byte("secretkey")).Write(bytes.Clone(plain))
+	case "ctr":
+		return encryptCTR(t, testAESBlock(t), plain)
+	case "chacha":
+		w, err := NewChaChaWriter(&buf, testChaChaKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plain)
+	case "gcm":
+		w, err := NewGCMWriter(&buf, testAESBlock(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plain)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case "cbc":
+		// the iv then the pkcs7 padded plaintext
+		padding := aes.BlockSize - len(plain)%aes.BlockSize
+		padded := append(bytes.Clone(plain), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		iv := []byte("an iv of a block")
+		buf.Write(iv)
+		ciphertext := make([]byte, len(padded))
+		cipher.NewCBCEncrypter(testAESBlock(t), iv).CryptBlocks(ciphertext, padded)
+		buf.Write(ciphertext)
+	default:
+		t.Fatalf("unknown scheme %q", scheme)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,45 @@
+package s3fileserver
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// the limits s3 puts on the tags of an object, lengths are in unicode
+// characters
+const (
+	maxObjectTags     = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// Errors returned by PutObjectTagging for tags s3 would reject, wrapped with
+// the offending tag.
+var (
+	ErrTooManyTags     = errors.New("too many object tags")
+	ErrEmptyTagKey     = errors.New("empty object tag key")
+	ErrTagKeyTooLong   = errors.New("object tag key too long")
+	ErrTagValueTooLong = errors.New("object tag value too long")
+)
+
+// validateTags checks tags against the s3 limits before they are sent.
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxObjectTags {
+		return fmt.Errorf("%w, %d tags, at most %d are allowed", ErrTooManyTags, len(tags), maxObjectTags)
+	}
+
+	for key, value := range tags {
+		if key == "" {
+			return ErrEmptyTagKey
+		}
+		if n := utf8.RuneCountInString(key); n > maxTagKeyLength {
+			return fmt.Errorf("%w, key %q is %d characters, at most %d are allowed", ErrTagKeyTooLong, key, n, maxTagKeyLength)
+		}
+		if n := utf8.RuneCountInString(value); n > maxTagValueLength {
+			return fmt.Errorf("%w, value of %q is %d characters, at most %d are allowed", ErrTagValueTooLong, key, n, maxTagValueLength)
+		}
+	}
+
+	return nil
+}
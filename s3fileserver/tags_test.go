@@ -0,0 +1,70 @@
+package s3fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateTags(t *testing.T) {
+	tooMany := map[string]string{}
+	for i := range maxObjectTags + 1 {
+		tooMany[fmt.Sprint("k", i)] = "v"
+	}
+	full := map[string]string{}
+	for i := range maxObjectTags {
+		full[fmt.Sprint("k", i)] = "v"
+	}
+
+	tests := []struct {
+		name string
+		tags map[string]string
+		want error
+	}{
+		{name: "none"},
+		{name: "at the limits", tags: map[string]string{strings.Repeat("k", maxTagKeyLength): strings.Repeat("é", maxTagValueLength)}},
+		{name: "ten tags", tags: full},
+		{name: "too many", tags: tooMany, want: ErrTooManyTags},
+		{name: "empty key", tags: map[string]string{"": "v"}, want: ErrEmptyTagKey},
+		{name: "long key", tags: map[string]string{strings.Repeat("k", maxTagKeyLength+1): "v"}, want: ErrTagKeyTooLong},
+		{name: "long value", tags: map[string]string{"k": strings.Repeat("v", maxTagValueLength+1)}, want: ErrTagValueTooLong},
+	}
+
+	for _, tt := range tests {
+		if err := validateTags(tt.tags); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestPutObjectTagging sends tags with characters a query string would need
+// escaped, and rejects invalid tags without calling s3.
+func TestPutObjectTagging(t *testing.T) {
+	var calls int
+	var body string
+	client := newTestS3Client(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		if _, ok := r.URL.Query()["tagging"]; !ok || r.Method != http.MethodPut {
+			t.Errorf("request %s %s", r.Method, r.URL)
+		}
+	})
+
+	tags := map[string]string{"name": "a&b=c d/é"}
+	if err := client.PutObjectTagging(context.Background(), "bucket", "a.bin", "", tags); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "<Key>name</Key><Value>a&amp;b=c d/é</Value>") {
+		t.Errorf("request body %s", body)
+	}
+
+	err := client.PutObjectTagging(context.Background(), "bucket", "a.bin", "", map[string]string{"": "v"})
+	if !errors.Is(err, ErrEmptyTagKey) || calls != 1 {
+		t.Errorf("invalid tags: err %v after %d calls", err, calls)
+	}
+}
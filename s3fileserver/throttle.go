@@ -0,0 +1,83 @@
+package s3fileserver
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// maxThrottleBurst bounds the bytes a throttled response sends at once, so
+// even fast rates are reached smoothly rather than in large bursts.
+const maxThrottleBurst = 64 << 10
+
+type bandwidthKey struct{}
+
+// signedBandwidth returns the bytes per second granted by the bps parameter
+// of a signed url, ok is false when the url did not set one.
+func signedBandwidth(ctx context.Context) (bytesPerSec int64, ok bool) {
+	bytesPerSec, ok = ctx.Value(bandwidthKey{}).(int64)
+	return bytesPerSec, ok
+}
+
+// throttle limits the bandwidth of every response to bytesPerSec. A signed
+// url may grant another rate, zero for full speed.
+type throttle struct {
+	bytesPerSec int64
+}
+
+func newThrottle(bytesPerSec int64) throttle {
+	return throttle{bytesPerSec: bytesPerSec}
+}
+
+func (t throttle) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bytesPerSec := t.bytesPerSec
+		if granted, ok := signedBandwidth(r.Context()); ok {
+			bytesPerSec = granted
+		}
+		if bytesPerSec <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(newThrottledWriter(r.Context(), w, bytesPerSec), r)
+	})
+}
+
+// throttledWriter writes the response body at no more than its rate.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func newThrottledWriter(ctx context.Context, w http.ResponseWriter, bytesPerSec int64) *throttledWriter {
+	burst := int(min(bytesPerSec, maxThrottleBurst))
+	limiter := rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+	return &throttledWriter{ResponseWriter: w, ctx: ctx, limiter: limiter}
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), w.limiter.Burst())
+		if err := w.limiter.WaitN(w.ctx, n); err != nil {
+			return written, err
+		}
+
+		n, err := w.ResponseWriter.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
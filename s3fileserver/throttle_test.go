@@ -0,0 +1,37 @@
+package s3fileserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// discardResponse is a ResponseWriter dropping the body.
+type discardResponse struct {
+	header http.Header
+}
+
+func (w *discardResponse) Header() http.Header         { return w.header }
+func (w *discardResponse) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardResponse) WriteHeader(int)             {}
+
+// BenchmarkThrottledWriter writes 32 KiB chunks at several rates and reports
+// the rate achieved, to compare with the limit. The first burst is free, so
+// short runs come out slightly above it.
+func BenchmarkThrottledWriter(b *testing.B) {
+	buf := make([]byte, 32<<10)
+	for _, bytesPerSec := range []int64{1 << 20, 16 << 20, 256 << 20} {
+		b.Run(fmt.Sprintf("%dMiB", bytesPerSec>>20), func(b *testing.B) {
+			w := newThrottledWriter(context.Background(), &discardResponse{header: http.Header{}}, bytesPerSec)
+			b.SetBytes(int64(len(buf)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := w.Write(buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*len(buf))/b.Elapsed().Seconds(), "bytes/s")
+		})
+	}
+}
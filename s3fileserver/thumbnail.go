@@ -0,0 +1,245 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	defaultThumbnailMaxSize   = 20 << 20
+	defaultThumbnailMaxPixels = 40_000_000
+
+	// maxThumbnailDimension bounds the w and h query parameters
+	maxThumbnailDimension = 4096
+	thumbnailQuality      = 85
+)
+
+// thumbnailTypes are the content types /thumbnail/ can decode.
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+var errThumbnailTooLarge = errors.New("image is too large for a thumbnail")
+
+// thumbnailLimits bounds the images /thumbnail/ decodes, so a small but
+// highly compressed image cannot take up the memory of the server. maxSize
+// limits the decrypted bytes and maxPixels the decoded image.
+type thumbnailLimits struct {
+	maxSize   int64
+	maxPixels int64
+}
+
+func newThumbnailLimits(maxSize int64, maxPixels int64) thumbnailLimits {
+	return thumbnailLimits{maxSize: maxSize, maxPixels: maxPixels}
+}
+
+// ServeThumbnail serves an image object scaled down to fit the w and h query
+// parameters as a jpeg, keeping its aspect ratio. The object is decrypted by
+// the scheme and key of its tags, like ServeFile does. Only whole images are
+// served, range requests get the whole thumbnail.
+func (h HTTPFileServer) ServeThumbnail(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/thumbnail/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	width, height, err := thumbnailBounds(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meta, tagMap, ok := h.objectInfo(w, r, bucket, objKey)
+	if !ok {
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(meta.contentType)
+	if !thumbnailTypes[mediaType] {
+		http.Error(w, fmt.Sprintf("cannot make a thumbnail of %q", meta.contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	if meta.contentLength > h.thumbnails.maxSize {
+		http.Error(w, errThumbnailTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// the thumbnail is a representation of its own, tagged by the object
+	// etag and the bounds
+	etag := meta.etag
+	if tag, ok := tagMap["File-Checksum-Original"]; ok {
+		etag = tag
+	}
+	if etag != "" {
+		etag = fmt.Sprintf(`"%s-%dx%d"`, strings.Trim(strings.TrimPrefix(etag, "W/"), `"`), width, height)
+		w.Header().Set("ETag", etag)
+	}
+	h.setCacheControl(w, tagMap)
+	setLastModified(w, meta.lastModified)
+
+	if !checkPreconditions(w, r, etag, meta.lastModified) {
+		return
+	}
+
+	data, status, err := h.readTagged(r, objKey, meta, tagMap)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	thumbnail, err := h.thumbnails.resize(data, width, height)
+	if errors.Is(err, errThumbnailTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode image, err: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(thumbnail)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(thumbnail)
+}
+
+// thumbnailBounds parses the w and h query parameters, a missing one leaves
+// that side unbounded but one of them is required.
+func thumbnailBounds(query url.Values) (width int, height int, err error) {
+	parse := func(name string) (int, error) {
+		value := query.Get(name)
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxThumbnailDimension {
+			return 0, fmt.Errorf("invalid %s %q, must be 1 to %d", name, value, maxThumbnailDimension)
+		}
+		return n, nil
+	}
+
+	if width, err = parse("w"); err != nil {
+		return 0, 0, err
+	}
+	if height, err = parse("h"); err != nil {
+		return 0, 0, err
+	}
+	if width == 0 && height == 0 {
+		return 0, 0, errors.New("missing w or h")
+	}
+	return width, height, nil
+}
+
+// readTagged returns the whole plaintext of an object, read through
+// serveTagged so integrity checks and stored encodings apply as for any
+// download. On error status is the status to answer with.
+func (h HTTPFileServer) readTagged(r *http.Request, objKey string, meta objectMeta, tagMap map[string]string) (data []byte, status int, err error) {
+	inner := r.Clone(r.Context())
+	inner.Method = http.MethodGet
+	for _, name := range []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		inner.Header.Del(name)
+	}
+	inner.Header.Set("Accept-Encoding", "identity")
+
+	rec := &bodyRecorder{header: http.Header{}, max: h.thumbnails.maxSize}
+	h.serveTagged(rec, inner, objKey, meta, tagMap)
+
+	if rec.overflow {
+		return nil, http.StatusRequestEntityTooLarge, errThumbnailTooLarge
+	}
+	if rec.status != http.StatusOK {
+		return nil, rec.status, errors.New(strings.TrimSpace(rec.body.String()))
+	}
+
+	// a body cut short has already been logged by the download
+	if length, err := strconv.Atoi(rec.header.Get("Content-Length")); err == nil && length != rec.body.Len() {
+		return nil, http.StatusBadGateway, errors.New("failed to read image")
+	}
+	return rec.body.Bytes(), http.StatusOK, nil
+}
+
+// resize decodes an image and encodes it as a jpeg fitting width and height,
+// zero leaving a side unbounded. Images are never scaled up.
+func (l thumbnailLimits) resize(data []byte, width int, height int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > l.maxPixels {
+		return nil, errThumbnailTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	scale := 1.0
+	if width > 0 && bounds.Dx() > width {
+		scale = float64(width) / float64(bounds.Dx())
+	}
+	if height > 0 && float64(bounds.Dy())*scale > float64(height) {
+		scale = float64(height) / float64(bounds.Dy())
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(1, int(float64(bounds.Dx())*scale)), max(1, int(float64(bounds.Dy())*scale))))
+
+	// jpeg has no alpha, transparent pixels end up white
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// bodyRecorder keeps a response in memory, up to max body bytes.
+type bodyRecorder struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	max      int64
+	overflow bool
+}
+
+func (rec *bodyRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *bodyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *bodyRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if int64(rec.body.Len()+len(p)) > rec.max {
+		rec.overflow = true
+		return 0, errThumbnailTooLarge
+	}
+	return rec.body.Write(p)
+}
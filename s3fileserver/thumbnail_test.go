@@ -0,0 +1,102 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"testing"
+)
+
+// testPNG returns a png of width by height pixels.
+func testPNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestThumbnail scales an encrypted png down to fit the bounds, keeping its
+// aspect ratio and never scaling it up.
+func TestThumbnail(t *testing.T) {
+	store := newMemStore()
+	store.put("a.png", encryptObject(t, "ctr", testPNG(t, 400, 200)), "image/png", map[string]string{"encryption": "ctr"})
+	h := newTestServer(t, store, nil)
+
+	tests := []struct {
+		query         string
+		width, height int
+	}{
+		{query: "w=100", width: 100, height: 50},
+		{query: "h=25", width: 50, height: 25},
+		{query: "w=100&h=10", width: 20, height: 10},
+		{query: "w=1000&h=1000", width: 400, height: 200},
+	}
+
+	for _, tt := range tests {
+		w := serve(h, http.MethodGet, "/thumbnail/a.png?"+tt.query, nil, "Range", "bytes=0-9")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" {
+			t.Fatalf("%s: status %d, Content-Type %q: %s", tt.query, w.Code, w.Header().Get("Content-Type"), w.Body)
+		}
+		config, err := jpeg.DecodeConfig(w.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if config.Width != tt.width || config.Height != tt.height {
+			t.Errorf("%s: %dx%d, want %dx%d", tt.query, config.Width, config.Height, tt.width, tt.height)
+		}
+	}
+
+	w := serve(h, http.MethodGet, "/thumbnail/a.png?w=100", nil)
+	etag := w.Header().Get("ETag")
+	if etag == "" || etag == serve(h, http.MethodGet, "/thumbnail/a.png?w=50", nil).Header().Get("ETag") {
+		t.Errorf("ETag %q is not specific to the bounds", etag)
+	}
+	if w := serve(h, http.MethodGet, "/thumbnail/a.png?w=100", nil, "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status %d, want 304", w.Code)
+	}
+}
+
+func TestThumbnailRejects(t *testing.T) {
+	store := newMemStore()
+	store.put("a.png", testPNG(t, 400, 200), "image/png", nil)
+	store.put("a.txt", []byte("not an image"), "text/plain", nil)
+	store.put("broken.png", []byte("not a png"), "image/png", nil)
+
+	tests := []struct {
+		name   string
+		target string
+		limits thumbnailLimits
+		status int
+	}{
+		{name: "no bounds", target: "/thumbnail/a.png", status: http.StatusBadRequest},
+		{name: "zero width", target: "/thumbnail/a.png?w=0", status: http.StatusBadRequest},
+		{name: "width too large", target: "/thumbnail/a.png?w=4097", status: http.StatusBadRequest},
+		{name: "not an image", target: "/thumbnail/a.txt?w=100", status: http.StatusUnsupportedMediaType},
+		{name: "undecodable", target: "/thumbnail/broken.png?w=100", status: http.StatusUnprocessableEntity},
+		{name: "object too large", target: "/thumbnail/a.png?w=100", limits: newThumbnailLimits(100, defaultThumbnailMaxPixels), status: http.StatusRequestEntityTooLarge},
+		{name: "too many pixels", target: "/thumbnail/a.png?w=100", limits: newThumbnailLimits(defaultThumbnailMaxSize, 400*200-1), status: http.StatusRequestEntityTooLarge},
+		{name: "missing", target: "/thumbnail/missing.png?w=100", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		h := newTestServer(t, store, func(opts *serverOptions) {
+			if tt.limits != (thumbnailLimits{}) {
+				opts.thumbnails = tt.limits
+			}
+		})
+		if w := serve(h, http.MethodGet, tt.target, nil); w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
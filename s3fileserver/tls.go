@@ -0,0 +1,54 @@
+package s3fileserver
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+)
+
+// loadTLSConfig builds the server tls config from either certificate files or
+// base64 encoded pem values. It returns nil when tls is not configured.
+func loadTLSConfig(certFile string, keyFile string, certBase64 string, keyBase64 string) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+
+	switch {
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	case certBase64 != "" || keyBase64 != "":
+		if certBase64 == "" || keyBase64 == "" {
+			return nil, errors.New("TLS_CERT_BASE64 and TLS_KEY_BASE64 must be set together")
+		}
+		certPEM, decodeErr := base64.StdEncoding.DecodeString(certBase64)
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		keyPEM, decodeErr := base64.StdEncoding.DecodeString(keyBase64)
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// only used for tls 1.2, tls 1.3 suites are not configurable
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}, nil
+}
@@ -0,0 +1,158 @@
+package s3fileserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type uploadResponse struct {
+	ETag string `json:"etag,omitempty"`
+	Size int64  `json:"size"`
+}
+
+// UploadCTRFile stores the request body aes-ctr encrypted.
+func (h HTTPFileServer) UploadCTRFile(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/ctr/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	block, err := h.aesKeys.get("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.uploadObject(w, r, bucket, objKey, "ctr", h.aesKeys.defaultID, func(writer io.Writer) (io.Writer, error) {
+		return NewCTRWriter(writer, block)
+	})
+}
+
+// UploadChaChaFile stores the request body chacha20 encrypted.
+func (h HTTPFileServer) UploadChaChaFile(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/chacha/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	key, err := h.chachaKeys.get("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.uploadObject(w, r, bucket, objKey, "chacha", h.chachaKeys.defaultID, func(writer io.Writer) (io.Writer, error) {
+		return NewChaChaWriter(writer, key)
+	})
+}
+
+// UploadXORFile stores the request body xor encrypted.
+func (h HTTPFileServer) UploadXORFile(w http.ResponseWriter, r *http.Request) {
+	// get the s3 object key from url
+	bucket, objKey, err := h.objectKey(r, "/xor/")
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	key, err := h.xorKeys.get("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.uploadObject(w, r, bucket, objKey, "xor", h.xorKeys.defaultID, func(writer io.Writer) (io.Writer, error) {
+		return NewXorWriter(writer, key), nil
+	})
+}
+
+// uploadObject streams the request body to s3 through the encrypting writer
+// returned by newWriter. The request content type is stored on the object so
+// downloads serve it back. The object is tagged with its scheme and key id,
+// so /file/ can decrypt it, and with an hmac key the hmac of the plaintext.
+func (h HTTPFileServer) uploadObject(w http.ResponseWriter, r *http.Request, bucket string, objKey string, scheme string, keyID string, newWriter func(io.Writer) (io.Writer, error)) {
+	// refuse a declared oversize body before reading any of it, so a client
+	// sending Expect: 100-continue is turned away before the transfer, the
+	// server only sends 100 Continue once the body is first read
+	if r.ContentLength > h.maxUploadSize {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// limit the upload size
+	var body io.Reader = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+
+	// the hmac of the plaintext is computed on the way to the encrypting
+	// writer and stored as the hmac tag downloads are verified against
+	var mac hash.Hash
+	if len(h.hmacKey) != 0 {
+		mac = hmac.New(sha256.New, h.hmacKey)
+		body = io.TeeReader(body, mac)
+	}
+
+	// encrypt the body while it is streamed to s3
+	pr, pw := io.Pipe()
+	copied := make(chan error, 1)
+	var size int64
+	go func() {
+		encWriter, err := newWriter(pw)
+		if err == nil {
+			size, err = h.buffers.copy(encWriter, body)
+		}
+		pw.CloseWithError(err)
+		copied <- err
+	}()
+
+	uploadOut, err := h.s3Client.PutObject(r.Context(), bucket, objKey, pr, r.Header.Get("Content-Type"))
+	pr.CloseWithError(err)
+	copyErr := <-copied
+	h.headCache.Remove(objectCacheKey(bucket, objKey))
+	h.missCache.Remove(objectCacheKey(bucket, objKey))
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(copyErr, &maxBytesErr) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tags := map[string]string{"encryption": scheme, "key-id": keyID}
+	if mac != nil {
+		tags["hmac"] = hex.EncodeToString(mac.Sum(nil))
+	}
+	if err := h.s3Client.PutObjectTagging(r.Context(), bucket, objKey, aws.ToString(uploadOut.VersionID), tags); err != nil {
+		http.Error(w, "object stored but its tags could not be set, err: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeUploadResponse(w, r, objKey, uploadOut.ETag, size)
+}
+
+func writeUploadResponse(w http.ResponseWriter, r *http.Request, objKey string, etag *string, size int64) {
+	resp := uploadResponse{Size: size}
+	if etag != nil {
+		resp.ETag = *etag
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.ErrorContext(r.Context(), "failed to write upload response", "object_key", objKey, "err", err)
+	}
+}
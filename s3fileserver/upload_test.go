@@ -0,0 +1,113 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestUploadRoundTrip uploads through every upload endpoint and downloads
+// the object back with its content type.
+func TestUploadRoundTrip(t *testing.T) {
+	plain := testPlaintext(100 << 10)
+
+	for _, scheme := range []string{"xor", "ctr", "chacha"} {
+		store := newMemStore()
+		h := newTestServer(t, store, nil)
+		target := "/" + scheme + "/docs/report.pdf"
+
+		w := serve(h, http.MethodPut, target, bytes.NewReader(plain), "Content-Type", "application/pdf")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: upload status %d: %s", scheme, w.Code, w.Body)
+		}
+		var resp uploadResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		if resp.Size != int64(len(plain)) || resp.ETag == "" {
+			t.Errorf("%s: upload response %+v", scheme, resp)
+		}
+
+		obj, ok := store.object("bucket", "docs/report.pdf")
+		if !ok {
+			t.Fatalf("%s: object not stored", scheme)
+		}
+		if bytes.Contains(obj.data, plain[:64]) {
+			t.Errorf("%s: object stored in the clear", scheme)
+		}
+
+		w = serve(h, http.MethodGet, target, nil)
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+			t.Fatalf("%s: download status %d, body matches %v", scheme, w.Code, bytes.Equal(w.Body.Bytes(), plain))
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/pdf" {
+			t.Errorf("%s: Content-Type %q", scheme, got)
+		}
+
+		// the tags tell /file/ how to decrypt the upload
+		if obj.tags["encryption"] != scheme || obj.tags["key-id"] != defaultKeyID {
+			t.Errorf("%s: tags %v", scheme, obj.tags)
+		}
+		w = serve(h, http.MethodGet, "/file/docs/report.pdf", nil)
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+			t.Errorf("%s: /file/ status %d, body matches %v", scheme, w.Code, bytes.Equal(w.Body.Bytes(), plain))
+		}
+	}
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	store := newMemStore()
+	h := newTestServer(t, store, func(opts *serverOptions) { opts.maxUploadSize = 10 })
+
+	// declared too large up front
+	if w := serve(h, http.MethodPut, "/xor/a.bin", strings.NewReader(strings.Repeat("a", 11))); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d, want 413", w.Code)
+	}
+
+	// found too large while streaming, a body of an unknown length
+	w := serve(h, http.MethodPut, "/xor/b.bin", struct{ io.Reader }{strings.NewReader(strings.Repeat("a", 11))})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("streamed: status %d, want 413", w.Code)
+	}
+}
+
+// TestUploadHMACTag uploads with an hmac key, the object is tagged with the
+// hmac of the plaintext and downloads verify against it.
+func TestUploadHMACTag(t *testing.T) {
+	plain := testPlaintext(10 << 10)
+
+	for _, scheme := range []string{"xor", "ctr", "chacha"} {
+		store := newMemStore()
+		h := newTestServer(t, store, func(opts *serverOptions) {
+			opts.hmacKey = testHMACKey
+			opts.hmacStrictMaxSize = 1 << 20
+		})
+		target := "/" + scheme + "/a.bin"
+
+		if w := serve(h, http.MethodPut, target, bytes.NewReader(plain)); w.Code != http.StatusCreated {
+			t.Fatalf("%s: upload status %d: %s", scheme, w.Code, w.Body)
+		}
+		obj, _ := store.object("bucket", "a.bin")
+		if got := obj.tags["hmac"]; got != testHMAC(plain) {
+			t.Fatalf("%s: hmac tag %q, want %q", scheme, got, testHMAC(plain))
+		}
+
+		w := serve(h, http.MethodGet, target, nil)
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), plain) {
+			t.Errorf("%s: download status %d", scheme, w.Code)
+		}
+	}
+
+	// without a key there is nothing to tag
+	store := newMemStore()
+	h := newTestServer(t, store, nil)
+	if w := serve(h, http.MethodPut, "/xor/a.bin", bytes.NewReader(plain)); w.Code != http.StatusCreated {
+		t.Fatalf("upload status %d", w.Code)
+	}
+	if obj, _ := store.object("bucket", "a.bin"); obj.tags["hmac"] != "" {
+		t.Errorf("hmac tag %q without an hmac key", obj.tags["hmac"])
+	}
+}
@@ -0,0 +1,113 @@
+package s3fileserver
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+type xorReader struct {
+	reader io.Reader
+	stream []byte
+	offset int64
+}
+
+// NewXorReader decrypts reader, which must be positioned at offset of the
+// ciphertext.
+func NewXorReader(reader io.Reader, key []byte, offset int64) *xorReader {
+	return &xorReader{
+		reader: reader,
+		stream: expandXORKey(key),
+		offset: offset,
+	}
+}
+
+// NewXorStringReader is NewXorReader for a key held as a string.
+func NewXorStringReader(reader io.Reader, key string, offset int64) *xorReader {
+	return NewXorReader(reader, []byte(key), offset)
+}
+
+func (r *xorReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+
+	xorKeyStream(p[:n], r.stream, r.offset)
+	r.offset += int64(n)
+
+	return n, err
+}
+
+type xorWriter struct {
+	writer io.Writer
+	stream []byte
+	offset int64
+}
+
+// NewXorWriter encrypts to writer from the start of the key.
+func NewXorWriter(writer io.Writer, key []byte) *xorWriter {
+	return &xorWriter{
+		writer: writer,
+		stream: expandXORKey(key),
+	}
+}
+
+// NewXorStringWriter is NewXorWriter for a key held as a string.
+func NewXorStringWriter(writer io.Writer, key string) *xorWriter {
+	return NewXorWriter(writer, []byte(key))
+}
+
+func (r *xorWriter) Write(p []byte) (n int, err error) {
+	xorKeyStream(p, r.stream, r.offset)
+	r.offset += int64(len(p))
+
+	return r.writer.Write(p)
+}
+
+// expandXORKey repeats key up to the least common multiple of its length
+// and 8, so the key stream lines up with whole words.
+func expandXORKey(key []byte) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+
+	n := len(key)
+	for n%8 != 0 {
+		n += len(key)
+	}
+
+	stream := make([]byte, 0, n)
+	for len(stream) < n {
+		stream = append(stream, key...)
+	}
+	return stream
+}
+
+// xorKeyStream xors p with the expanded key stream, starting offset bytes
+// into the repeated key.
+func xorKeyStream(p []byte, stream []byte, offset int64) {
+	streamLen := int64(len(stream))
+	k := offset % streamLen
+	i := 0
+
+	// xor up to a word boundary, the stream length is a multiple of 8 so
+	// this never wraps
+	for ; i < len(p) && k%8 != 0; i++ {
+		p[i] ^= stream[k]
+		k++
+	}
+
+	// then a whole word at a time
+	for ; i+8 <= len(p); i += 8 {
+		if k == streamLen {
+			k = 0
+		}
+		binary.LittleEndian.PutUint64(p[i:], binary.LittleEndian.Uint64(p[i:])^binary.LittleEndian.Uint64(stream[k:]))
+		k += 8
+	}
+
+	for ; i < len(p); i++ {
+		if k == streamLen {
+			k = 0
+		}
+		p[i] ^= stream[k]
+		k++
+	}
+}
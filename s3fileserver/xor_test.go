@@ -0,0 +1,132 @@
+package s3fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// xorKeyStreamBytewise is the byte at a time loop xorKeyStream replaced,
+// kept as the reference to compare against.
+func xorKeyStreamBytewise(p []byte, key []byte, offset int64) {
+	for i := range p {
+		p[i] ^= key[(offset+int64(i))%int64(len(key))]
+	}
+}
+
+func TestXORKeyStreamMatchesBytewise(t *testing.T) {
+	for _, keyLen := range []int{1, 3, 8, 9, 16, 32, 33} {
+		key := make([]byte, keyLen)
+		for i := range key {
+			key[i] = byte(i*31 + 7)
+		}
+		stream := expandXORKey(key)
+
+		for offset := int64(0); offset < 80; offset++ {
+			for _, n := range []int{0, 1, 7, 8, 9, 63, 200} {
+				want := bytes.Repeat([]byte{0xa5}, n)
+				got := bytes.Clone(want)
+				xorKeyStreamBytewise(want, key, offset)
+				xorKeyStream(got, stream, offset)
+				if !bytes.Equal(got, want) {
+					t.Fatalf("key %d offset %d length %d: got %x, want %x", keyLen, offset, n, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestXORRoundTripOffsets encrypts with the writer and decrypts from every
+// offset within a few key lengths, the reader must pick up the key stream
+// where the writer was.
+func TestXORRoundTripOffsets(t *testing.T) {
+	key := []byte("secretkey")
+	plain := testPlaintext(1 << 10)
+
+	var buf bytes.Buffer
+	// written in uneven pieces, the writer keeps its offset across writes
+	w := NewXorWriter(&buf, key)
+	for rest := bytes.Clone(plain); len(rest) > 0; {
+		n := min(len(rest), 13)
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	ciphertext := buf.Bytes()
+
+	for offset := 0; offset <= 4*len(key)+8; offset++ {
+		got, err := io.ReadAll(&oneByteReader{NewXorReader(bytes.NewReader(ciphertext[offset:]), key, int64(offset))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain[offset:]) {
+			t.Fatalf("offset %d does not match the plaintext", offset)
+		}
+	}
+}
+
+func BenchmarkXORKeyStream(b *testing.B) {
+	buf := make([]byte, 32<<10)
+	for _, keyLen := range []int{9, 16, 32} {
+		key := make([]byte, keyLen)
+		for i := range key {
+			key[i] = byte(i*31 + 7)
+		}
+		stream := expandXORKey(key)
+
+		b.Run(fmt.Sprintf("bytewise/key%d", keyLen), func(b *testing.B) {
+			b.SetBytes(int64(len(buf)))
+			for i := 0; i < b.N; i++ {
+				xorKeyStreamBytewise(buf, key, int64(i))
+			}
+		})
+		b.Run(fmt.Sprintf("words/key%d", keyLen), func(b *testing.B) {
+			b.SetBytes(int64(len(buf)))
+			for i := 0; i < b.N; i++ {
+				xorKeyStream(buf, stream, int64(i))
+			}
+		})
+	}
+}
+
+// bytewiseXORReader is xorReader as it was before the key was expanded to
+// whole words.
+type bytewiseXORReader struct {
+	reader io.Reader
+	key    []byte
+	offset int64
+}
+
+func (r *bytewiseXORReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	xorKeyStreamBytewise(p[:n], r.key, r.offset)
+	r.offset += int64(n)
+	return n, err
+}
+
+// BenchmarkXORReader reads 1 MiB through the byte loop and the word loop,
+// the word loop is expected to be well over 3x faster.
+func BenchmarkXORReader(b *testing.B) {
+	data := make([]byte, 1<<20)
+	buf := make([]byte, 32<<10)
+	key := []byte("secretkey")
+
+	read := func(b *testing.B, newReader func(r io.Reader) io.Reader) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			reader := newReader(bytes.NewReader(data))
+			if _, err := io.CopyBuffer(io.Discard, struct{ io.Reader }{reader}, buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("bytewise", func(b *testing.B) {
+		read(b, func(r io.Reader) io.Reader { return &bytewiseXORReader{reader: r, key: key, offset: 3} })
+	})
+	b.Run("words", func(b *testing.B) {
+		read(b, func(r io.Reader) io.Reader { return NewXorReader(r, key, 3) })
+	})
+}